@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/model"
+	"gorm.io/gorm"
+)
+
+// EnsureBootstrapAdmin gives the configured account the admin role, creating
+// it if needed, but only while there is no admin at all. After that it does
+// nothing, so role changes made through the api stick.
+func EnsureBootstrapAdmin(cfg *config.BootstrapAdmin) error {
+	if cfg.Username == "" {
+		return nil
+	}
+
+	var admins int64
+	err := database.DB.Table("user_roles").
+		Joins("JOIN roles ON roles.id = user_roles.role_id").
+		Where("roles.name = ?", config.RoleAdmin).
+		Count(&admins).Error
+
+	if err != nil {
+		return err
+	}
+
+	if admins > 0 {
+		return nil
+	}
+
+	var user model.User
+	err = database.DB.Where("username = ?", cfg.Username).First(&user).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if cfg.Email == "" || cfg.Password == "" {
+			return fmt.Errorf("creating the admin %q needs an email and a password", cfg.Username)
+		}
+
+		user = model.User{
+			Username:      cfg.Username,
+			Email:         cfg.Email,
+			Password:      cfg.Password,
+			EmailVerified: true,
+			AuthSource:    config.AuthSourceLocal,
+		}
+
+		if err := CheckPasswordPolicy(cfg.Password, &user); err != nil {
+			return err
+		}
+
+		if err := database.DB.Create(&user).Error; err != nil {
+			return err
+		}
+
+		if err := RecordPassword(&user); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	loaded, err := LoadUser(user.Id)
+	if err != nil {
+		return err
+	}
+
+	if err := AssignRoles(loaded, append(loaded.RoleNames(), config.RoleAdmin)); err != nil {
+		return err
+	}
+
+	log.Printf("gave %s the admin role", cfg.Username)
+
+	return nil
+}
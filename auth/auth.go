@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"strconv"
 	"strings"
@@ -11,32 +13,70 @@ import (
 	"github.com/yosikez/crudAuth/config"
 	"github.com/yosikez/crudAuth/database"
 	"github.com/yosikez/crudAuth/model"
-	"golang.org/x/crypto/bcrypt"
 )
 
-func GenerateTokens(userId uint, username string, email string) (accessToken, refreshToken string, err error) {
+func newTokenId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func NewSessionId() (string, error) {
+	return newTokenId()
+}
+
+// GenerateTokens issues an access token carrying the user's roles and
+// permissions, so user.Roles.Permissions has to be preloaded (see LoadUser).
+// Both tokens carry scopes and the oauth client, if any, so refreshing keeps
+// them as they were at login.
+func GenerateTokens(user *model.User, sessionId, clientId string, scopes []string) (accessToken, refreshToken string, err error) {
+	jti, err := newTokenId()
+	if err != nil {
+		return "", "", errors.New("failed to generate token id")
+	}
+
 	accessTokenClaims := config.Claims{
-		Id:       userId,
-		Username: username,
-		Email:    email,
+		Id:            user.Id,
+		Username:      user.Username,
+		Email:         user.Email,
+		SessionId:     sessionId,
+		EmailVerified: user.EmailVerified,
+		Roles:         user.RoleNames(),
+		Permissions:   user.PermissionNames(),
+		Scope:         FormatScope(scopes),
+		ClientId:      clientId,
 		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
 			ExpiresAt: time.Now().Add(config.AccessTokenDuration).Unix(),
+			Issuer:    config.RefreshTokenIssuer,
+			Audience:  config.AccessTokenAudience,
 		},
 	}
 
-	accessTokenToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessTokenClaims)
-	accessToken, err = accessTokenToken.SignedString([]byte(config.SecretKey))
+	accessToken, err = SignToken(accessTokenClaims)
 
 	if err != nil {
 		return "", "", errors.New("failed to generate access token")
 	}
-	userIDStr := strconv.Itoa(int(userId))
+	userIDStr := strconv.Itoa(int(user.Id))
+
+	refreshTokenId, err := newTokenId()
+	if err != nil {
+		return "", "", errors.New("failed to generate token id")
+	}
 
 	refreshTokenClaims := config.Claims{
-		Id:       userId,
-		Username: username,
-		Email:    email,
+		Id:        user.Id,
+		Username:  user.Username,
+		Email:     user.Email,
+		SessionId: sessionId,
+		Scope:     FormatScope(scopes),
+		ClientId:  clientId,
 		StandardClaims: jwt.StandardClaims{
+			Id:        refreshTokenId,
 			ExpiresAt: time.Now().Add(config.RefreshTokenDuration).Unix(),
 			Issuer:    config.RefreshTokenIssuer,
 			Audience:  config.RefreshTokenAudience,
@@ -44,8 +84,7 @@ func GenerateTokens(userId uint, username string, email string) (accessToken, re
 		},
 	}
 
-	refreshTokenToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshTokenClaims)
-	refreshToken, err = refreshTokenToken.SignedString([]byte(config.SecretKey))
+	refreshToken, err = SignToken(refreshTokenClaims)
 
 	if err != nil {
 		return "", "", errors.New("failed to generate refresh token")
@@ -54,87 +93,76 @@ func GenerateTokens(userId uint, username string, email string) (accessToken, re
 	return accessToken, refreshToken, nil
 }
 
-func RefreshTokens(refreshToken string) (accessToken, newRefreshToken string, err error) {
-
-	token, err := jwt.ParseWithClaims(refreshToken, &config.Claims{}, func(t *jwt.Token) (interface{}, error) {
-		return []byte(config.SecretKey), nil
-	})
+func ParseRefreshToken(refreshToken string) (*config.Claims, error) {
+	token, err := ParseToken(refreshToken, &config.Claims{})
 
 	if err != nil {
 		if err == jwt.ErrSignatureInvalid {
-			return "", "", errors.New("invalid refresh token signature")
+			return nil, errors.New("invalid refresh token signature")
 		}
 
-		return "", "", errors.New("invalid refresh token")
+		return nil, errors.New("invalid refresh token")
 	}
 
 	claims, ok := token.Claims.(*config.Claims)
 
-	if !ok || !token.Valid || claims.Audience != config.RefreshTokenAudience || claims.Issuer != config.RefreshTokenIssuer {
-		return "", "", errors.New("token is not valid")
+	// a refresh token without scopes is refused, an empty scope would be
+	// read as the defaults
+	if !ok || !token.Valid || claims.Audience != config.RefreshTokenAudience || claims.Issuer != config.RefreshTokenIssuer || claims.Scope == "" {
+		return nil, errors.New("token is not valid")
 	}
 
-	accessTokenClaims := config.Claims{
-		Id:       claims.Id,
-		Username: claims.Username,
-		Email:    claims.Email,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(config.AccessTokenDuration).Unix(),
-		},
-	}
+	return claims, nil
+}
 
-	accessTokenToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessTokenClaims)
-	accessToken, err = accessTokenToken.SignedString([]byte(config.SecretKey))
+// RefreshTokens reloads the user so role changes are picked up on the next
+// refresh instead of being frozen in the refresh token.
+func RefreshTokens(refreshToken string) (accessToken, newRefreshToken string, err error) {
+	claims, err := ParseRefreshToken(refreshToken)
+	if err != nil {
+		return "", "", err
+	}
 
+	user, err := LoadUser(claims.Id)
 	if err != nil {
-		return "", "", errors.New("failed to generate access token")
+		return "", "", err
 	}
-	userIDStr := strconv.Itoa(int(claims.Id))
 
-	newRefreshTokenClaims := config.Claims{
-		Id:       claims.Id,
-		Username: claims.Username,
-		Email:    claims.Email,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(config.RefreshTokenDuration).Unix(),
-			Issuer:    config.RefreshTokenIssuer,
-			Audience:  config.RefreshTokenAudience,
-			Subject:   userIDStr,
-		},
+	if err := CheckUserStatus(user); err != nil {
+		return "", "", err
 	}
 
-	newRefreshTokenToken := jwt.NewWithClaims(jwt.SigningMethodHS256, newRefreshTokenClaims)
-	newRefreshToken, err = newRefreshTokenToken.SignedString([]byte(config.SecretKey))
+	scopes, err := ParseScope(claims.Scope)
+	if err != nil {
+		return "", "", err
+	}
 
+	accessToken, newRefreshToken, err = GenerateTokens(user, claims.SessionId, claims.ClientId, scopes)
 	if err != nil {
-		return "", "", errors.New("failed to generate new refresh token")
+		return "", "", err
 	}
 
 	return accessToken, newRefreshToken, nil
 }
 
-func AuthenticateUser(username, password string) (*model.User, error) {
-	var user model.User
-
-	if err := database.DB.Where("username = ?", username).First(&user).Error; err != nil {
-		return nil, err
-	}
-
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return nil, err
+func rehashPassword(user *model.User, password string) error {
+	// a password changed in the meantime is newer than the one being
+	// rehashed, so losing to it is fine
+	if err := setPassword(database.DB, user, password); err != nil && !errors.Is(err, ErrPasswordChanged) {
+		return err
 	}
 
-	return &user, nil
+	return nil
 }
 
-func GetRefrehToken(userId uint) (*model.RefreshToken, error) {
-	var existingToken model.RefreshToken
+func LoadUser(userId uint) (*model.User, error) {
+	var user model.User
 
-	if err := database.DB.Where("user_id = ?", userId).First(&existingToken).Error; err != nil {
+	if err := database.DB.Preload("Roles.Permissions").First(&user, userId).Error; err != nil {
 		return nil, err
 	}
 
-	return &existingToken, nil
+	return &user, nil
 }
 
 func GetClaimsDataFromToken(c *gin.Context) (*config.Claims, error) {
@@ -145,9 +173,7 @@ func GetClaimsDataFromToken(c *gin.Context) (*config.Claims, error) {
 
 	tokenStr := strings.Replace(header, "Bearer ", "", 1)
 
-	token, err := jwt.ParseWithClaims(tokenStr, &config.Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.SecretKey), nil
-	})
+	token, err := ParseToken(tokenStr, &config.Claims{})
 
 	if err != nil {
 		if err == jwt.ErrSignatureInvalid {
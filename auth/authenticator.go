@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/helper/hasher"
+	"github.com/yosikez/crudAuth/model"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUnknownUser              = errors.New("user is not known to this authenticator")
+	ErrAuthenticatorUnavailable = errors.New("the authentication backend is unavailable")
+)
+
+// Authenticator checks a username and password against one backend and
+// returns the local user, creating or syncing it when the backend is not the
+// users table. ErrUnknownUser hands the login to the next authenticator,
+// ErrAuthenticatorUnavailable says the backend could not be asked, which is
+// not the user's fault.
+type Authenticator interface {
+	Authenticate(username, password string) (*model.User, error)
+}
+
+var authenticators = []Authenticator{LocalAuthenticator{}}
+
+// InitAuthenticators builds the chain from the AUTH_BACKENDS names, ldapCfg
+// is only needed when ldap is one of them.
+func InitAuthenticators(backends []string, ldapCfg *config.Ldap) error {
+	chain := make([]Authenticator, 0, len(backends))
+
+	for _, backend := range backends {
+		switch backend {
+		case config.AuthSourceLocal:
+			chain = append(chain, LocalAuthenticator{})
+		case config.AuthSourceLdap:
+			if ldapCfg == nil {
+				return errors.New("the ldap authenticator needs an ldap config")
+			}
+
+			chain = append(chain, NewLdapAuthenticator(ldapCfg))
+		default:
+			return fmt.Errorf("unknown authenticator %q", backend)
+		}
+	}
+
+	SetAuthenticators(chain...)
+
+	return nil
+}
+
+func SetAuthenticators(chain ...Authenticator) {
+	authenticators = chain
+}
+
+// AuthenticateUser tries the authenticators in order until one of them
+// knows the user.
+func AuthenticateUser(username, password string) (*model.User, error) {
+	for _, authenticator := range authenticators {
+		user, err := authenticator.Authenticate(username, password)
+		if errors.Is(err, ErrUnknownUser) {
+			continue
+		}
+
+		if err != nil {
+			if !errors.Is(err, ErrInvalidPassword) {
+				log.Printf("authenticator %T failed for %q : %v", authenticator, username, err)
+			}
+
+			return nil, err
+		}
+
+		// only checked once the password matched, so the status of an account
+		// is not revealed to someone guessing
+		if err := CheckUserStatus(user); err != nil {
+			return user, err
+		}
+
+		return user, nil
+	}
+
+	return nil, ErrUnknownUser
+}
+
+// LocalAuthenticator checks the password hash in the users table. Users
+// from other sources are left to their own authenticator.
+type LocalAuthenticator struct{}
+
+func (LocalAuthenticator) Authenticate(username, password string) (*model.User, error) {
+	var user model.User
+
+	err := database.DB.Preload("Roles.Permissions").Where("username = ?", username).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUnknownUser
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if user.AuthSource != "" && user.AuthSource != config.AuthSourceLocal {
+		return nil, ErrUnknownUser
+	}
+
+	ok, err := hasher.Verify(user.Password, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, ErrInvalidPassword
+	}
+
+	// the password is known now, so a hash made with an older algorithm or
+	// weaker parameters can be replaced without the user noticing
+	if hasher.NeedsRehash(user.Password) {
+		if err := rehashPassword(&user, password); err != nil {
+			log.Printf("failed to rehash password of user %d : %v", user.Id, err)
+		}
+	}
+
+	return &user, nil
+}
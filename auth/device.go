@@ -0,0 +1,280 @@
+package auth
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/model"
+	"gorm.io/gorm"
+)
+
+// the polling errors are named after their rfc 8628 section 3.5 error codes
+var (
+	ErrAuthorizationPending = errors.New("the user has not approved the device yet")
+	ErrSlowDown             = errors.New("polling too fast, wait longer between requests")
+	ErrAccessDenied         = errors.New("the user denied the device")
+	ErrExpiredToken         = errors.New("the device code expired, start over")
+	ErrInvalidUserCode      = errors.New("invalid or expired code")
+	ErrUserCodeThrottled    = errors.New("too many wrong codes, try again later")
+)
+
+func userCodeKey(ip string) string {
+	return "device:" + ip
+}
+
+// CheckUserCodeLookupAllowed throttles the verification page per ip the way
+// CheckLoginAllowed throttles logins, rfc 8628 section 5.1: a user code is
+// short enough to be found by trying many of them. Every lookup is counted
+// up front, ForgiveUserCodeLookup takes it back when the code was found.
+func CheckUserCodeLookupAllowed(ip string) (time.Duration, error) {
+	wait, err := checkFailures(userCodeKey(ip))
+	if errors.Is(err, ErrLoginThrottled) || errors.Is(err, ErrAccountLocked) {
+		return wait, ErrUserCodeThrottled
+	}
+
+	return wait, err
+}
+
+func ForgiveUserCodeLookup(ip string) error {
+	return forgiveFailures(userCodeKey(ip))
+}
+
+func newUserCode() (string, error) {
+	charset := config.DeviceUserCodeCharset
+	code := make([]byte, config.DeviceUserCodeLength)
+
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
+		if err != nil {
+			return "", err
+		}
+
+		code[i] = charset[n.Int64()]
+	}
+
+	return string(code), nil
+}
+
+// NormalizeUserCode strips the dash and anything else users type around a
+// code, so "bcdf-ghjk" finds BCDFGHJK.
+func NormalizeUserCode(userCode string) string {
+	var b strings.Builder
+
+	for _, r := range strings.ToUpper(userCode) {
+		if r >= 'A' && r <= 'Z' {
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// FormatUserCode is how a code is shown, XXXX-XXXX.
+func FormatUserCode(userCode string) string {
+	if len(userCode) <= 4 {
+		return userCode
+	}
+
+	return userCode[:4] + "-" + userCode[4:]
+}
+
+// CreateDeviceCode starts a device authorization and returns the device
+// code, which only the device gets, and the user code the user enters.
+func CreateDeviceCode(client *model.OauthClient, scope string) (string, *model.OauthDeviceCode, error) {
+	if !client.AllowsGrant(config.GrantDeviceCode) {
+		return "", nil, ErrUnauthorizedClient
+	}
+
+	scopes, err := clientScopes(client, scope)
+	if err != nil {
+		return "", nil, err
+	}
+
+	deviceCode, err := newTokenId()
+	if err != nil {
+		return "", nil, err
+	}
+
+	userCode, err := newUserCode()
+	if err != nil {
+		return "", nil, err
+	}
+
+	// expired codes are cleaned up whenever a new one is made
+	if err := database.DB.Where("expires_at < ?", time.Now()).Delete(&model.OauthDeviceCode{}).Error; err != nil {
+		return "", nil, err
+	}
+
+	row := &model.OauthDeviceCode{
+		DeviceCodeHash: HashToken(deviceCode),
+		UserCode:       userCode,
+		ClientId:       client.Id,
+		Scope:          FormatScope(scopes),
+		Status:         model.DeviceCodePending,
+		PollInterval:   int(config.DeviceCodeInterval.Seconds()),
+		ExpiresAt:      time.Now().Add(config.DeviceCodeDuration),
+	}
+
+	if err := database.DB.Create(row).Error; err != nil {
+		return "", nil, err
+	}
+
+	return deviceCode, row, nil
+}
+
+// pendingUserCodes are the codes a user can still enter.
+func pendingUserCodes(now time.Time) *gorm.DB {
+	return database.DB.Model(&model.OauthDeviceCode{}).
+		Where("status = ? AND expires_at > ? AND failed_lookups < ?", model.DeviceCodePending, now, config.DeviceUserCodeMaxFailedLookups)
+}
+
+// LookupDeviceCode returns a pending device authorization and its client for
+// the verification page.
+func LookupDeviceCode(userCode string) (*model.OauthDeviceCode, *model.OauthClient, error) {
+	var row model.OauthDeviceCode
+
+	now := time.Now()
+
+	err := pendingUserCodes(now).Where("user_code = ?", NormalizeUserCode(userCode)).First(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// the per ip throttle does not stop guesses spread over many ips, so
+		// every wrong code also counts against all the codes it could have hit
+		if err := pendingUserCodes(now).Update("failed_lookups", gorm.Expr("failed_lookups + 1")).Error; err != nil {
+			return nil, nil, err
+		}
+
+		return nil, nil, ErrInvalidUserCode
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err := GetOAuthClient(row.ClientId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &row, client, nil
+}
+
+// DecideDeviceCode records the user's answer, a code can only be decided
+// once. Like on the consent page, denying does not need a login, so user is
+// nil then.
+func DecideDeviceCode(userCode string, user *model.User, approve bool) error {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status": model.DeviceCodeDenied,
+	}
+
+	if approve {
+		if user == nil {
+			return ErrInvalidUserCode
+		}
+
+		updates["status"] = model.DeviceCodeApproved
+		updates["user_id"] = user.Id
+		updates["auth_time"] = now
+	}
+
+	result := pendingUserCodes(now).Where("user_code = ?", NormalizeUserCode(userCode)).Updates(updates)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrInvalidUserCode
+	}
+
+	return nil
+}
+
+// PollDeviceCode answers a device polling the token endpoint. Once approved
+// the code is used up and the user and the granted scopes are returned.
+func PollDeviceCode(client *model.OauthClient, deviceCode string) (*model.User, *model.OauthDeviceCode, error) {
+	if !client.AllowsGrant(config.GrantDeviceCode) {
+		return nil, nil, ErrUnauthorizedClient
+	}
+
+	var row model.OauthDeviceCode
+
+	err := database.DB.Where("device_code_hash = ?", HashToken(deviceCode)).First(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrInvalidGrant
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if row.ClientId != client.Id {
+		return nil, nil, ErrInvalidGrant
+	}
+
+	now := time.Now()
+
+	if now.After(row.ExpiresAt) {
+		return nil, nil, ErrExpiredToken
+	}
+
+	if row.Status == model.DeviceCodePending && row.FailedLookups >= config.DeviceUserCodeMaxFailedLookups {
+		return nil, nil, ErrExpiredToken
+	}
+
+	switch row.Status {
+	case model.DeviceCodeDenied:
+		if err := database.DB.Where("device_code_hash = ?", row.DeviceCodeHash).Delete(&model.OauthDeviceCode{}).Error; err != nil {
+			return nil, nil, err
+		}
+
+		return nil, nil, ErrAccessDenied
+	case model.DeviceCodeApproved:
+		// deleted before the tokens are issued, so two polls racing each
+		// other only get one set
+		result := database.DB.Where("device_code_hash = ? AND status = ?", row.DeviceCodeHash, model.DeviceCodeApproved).Delete(&model.OauthDeviceCode{})
+		if result.Error != nil {
+			return nil, nil, result.Error
+		}
+
+		if result.RowsAffected == 0 || row.UserId == nil || row.AuthTime == nil {
+			return nil, nil, ErrInvalidGrant
+		}
+
+		user, err := LoadUser(*row.UserId)
+		if err != nil {
+			return nil, nil, ErrInvalidGrant
+		}
+
+		if err := CheckUserStatus(user); err != nil {
+			return nil, nil, ErrInvalidGrant
+		}
+
+		return user, &row, nil
+	}
+
+	interval := time.Duration(row.PollInterval) * time.Second
+	if row.LastPolledAt != nil && now.Sub(*row.LastPolledAt) < interval {
+		// rfc 8628 section 3.5, the interval grows by 5 seconds and stays
+		// that way for all later requests
+		if err := database.DB.Model(&row).Updates(map[string]interface{}{
+			"poll_interval":  row.PollInterval + int(config.DeviceCodeSlowDown.Seconds()),
+			"last_polled_at": now,
+		}).Error; err != nil {
+			return nil, nil, err
+		}
+
+		return nil, nil, ErrSlowDown
+	}
+
+	if err := database.DB.Model(&row).Update("last_polled_at", now).Error; err != nil {
+		return nil, nil, err
+	}
+
+	return nil, nil, ErrAuthorizationPending
+}
@@ -0,0 +1,348 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/yosikez/crudAuth/config"
+)
+
+var (
+	ErrExternalProvider = errors.New("the login provider could not be reached or returned an error")
+	ErrExternalIdToken  = errors.New("invalid id token from the login provider")
+)
+
+var externalClient = &http.Client{Timeout: config.ExternalProviderTimeout}
+
+// externalMetadata is the part of a provider's discovery document and keys
+// the login needs.
+type externalMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+var externalCache = struct {
+	mu       sync.Mutex
+	metadata map[string]*externalMetadata
+}{metadata: map[string]*externalMetadata{}}
+
+func getJSON(endpoint string, v interface{}) error {
+	resp, err := externalClient.Get(endpoint)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrExternalProvider, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s answered %d", ErrExternalProvider, endpoint, resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", ErrExternalProvider, err)
+	}
+
+	return nil
+}
+
+// providerMetadata returns the cached discovery document and keys of
+// provider, refresh forces them to be fetched again. The lock only guards the
+// map, a slow provider must not hold up logins with the others, so two
+// requests that miss at the same time both fetch and the last one wins.
+func providerMetadata(provider *config.ExternalProvider, refresh bool) (*externalMetadata, error) {
+	externalCache.mu.Lock()
+	cached := externalCache.metadata[provider.Name]
+	externalCache.mu.Unlock()
+
+	if cached != nil && !refresh && time.Since(cached.fetchedAt) < config.ExternalProviderCacheDuration {
+		return cached, nil
+	}
+
+	var metadata externalMetadata
+	if err := getJSON(provider.Issuer+"/.well-known/openid-configuration", &metadata); err != nil {
+		return nil, err
+	}
+
+	// openid connect discovery section 4.3, the document must be about the
+	// issuer it was fetched from
+	if strings.TrimRight(metadata.Issuer, "/") != provider.Issuer {
+		return nil, fmt.Errorf("%w: issuer mismatch %q", ErrExternalProvider, metadata.Issuer)
+	}
+
+	var jwks struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := getJSON(metadata.JwksURI, &jwks); err != nil {
+		return nil, err
+	}
+
+	metadata.keys = map[string]interface{}{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		// keys of unsupported types are skipped, the provider may publish
+		// keys for other purposes
+		if key, err := jwkPublicKey(jwk); err == nil {
+			metadata.keys[jwk.Kid] = key
+		}
+	}
+
+	metadata.fetchedAt = time.Now()
+
+	externalCache.mu.Lock()
+	externalCache.metadata[provider.Name] = &metadata
+	externalCache.mu.Unlock()
+
+	return &metadata, nil
+}
+
+func decodeJWKInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+// jwkPublicKey is the reverse of JWKS.
+func jwkPublicKey(jwk JWK) (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeJWKInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeJWKInt(jwk.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid rsa exponent")
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+
+		x, err := decodeJWKInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeJWKInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("ec point is not on the curve")
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || jwk.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key")
+		}
+
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+}
+
+// audience accepts both forms of the aud claim, a string or a list.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+
+	*a = list
+	return nil
+}
+
+// externalIdTokenClaims is an id token from another provider. email_verified
+// is not always a boolean in the wild, some providers send "true".
+type externalIdTokenClaims struct {
+	Issuer            string      `json:"iss"`
+	Subject           string      `json:"sub"`
+	Audience          audience    `json:"aud"`
+	AuthorizedParty   string      `json:"azp"`
+	ExpiresAt         int64       `json:"exp"`
+	IssuedAt          int64       `json:"iat"`
+	Nonce             string      `json:"nonce"`
+	Email             string      `json:"email"`
+	EmailVerified     interface{} `json:"email_verified"`
+	PreferredUsername string      `json:"preferred_username"`
+}
+
+func (c *externalIdTokenClaims) Valid() error {
+	if c.ExpiresAt == 0 || time.Now().Unix() > c.ExpiresAt {
+		return errors.New("id token is expired")
+	}
+
+	return nil
+}
+
+func (c *externalIdTokenClaims) emailVerified() bool {
+	switch verified := c.EmailVerified.(type) {
+	case bool:
+		return verified
+	case string:
+		return verified == "true"
+	default:
+		return false
+	}
+}
+
+// exchangeExternalCode redeems code at the provider's token endpoint and
+// returns its verified id token.
+func exchangeExternalCode(provider *config.ExternalProvider, code, redirectURI, codeVerifier, nonce string) (*externalIdTokenClaims, error) {
+	metadata, err := providerMetadata(provider, false)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {config.GrantAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {codeVerifier},
+	}
+
+	// public clients identify themselves in the form, confidential ones with
+	// basic auth
+	if provider.ClientSecret == "" {
+		form.Set("client_id", provider.ClientId)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	// rfc 6749 section 2.3.1, the credentials are form encoded before they
+	// go into basic auth
+	if provider.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(provider.ClientId), url.QueryEscape(provider.ClientSecret))
+	}
+
+	resp, err := externalClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExternalProvider, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExternalProvider, err)
+	}
+
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return nil, fmt.Errorf("%w: %s %s", ErrExternalProvider, body.Error, body.ErrorDescription)
+	}
+
+	return verifyExternalIdToken(provider, metadata, body.IdToken, nonce)
+}
+
+// verifyExternalIdToken follows openid connect core section 3.1.3.7.
+func verifyExternalIdToken(provider *config.ExternalProvider, metadata *externalMetadata, idToken, nonce string) (*externalIdTokenClaims, error) {
+	claims := &externalIdTokenClaims{}
+
+	_, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		// the key type decides which algorithms are accepted, so neither none
+		// nor an hmac with the public key as secret can get through
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA, *jwt.SigningMethodEd25519:
+		default:
+			return nil, fmt.Errorf("unexpected signing method %q", token.Method.Alg())
+		}
+
+		kid, _ := token.Header["kid"].(string)
+
+		key, ok := metadata.keys[kid]
+		if !ok {
+			// the provider may have rotated its keys since they were cached
+			refreshed, err := providerMetadata(provider, true)
+			if err != nil {
+				return nil, err
+			}
+
+			if key, ok = refreshed.keys[kid]; !ok {
+				return nil, fmt.Errorf("unknown kid %q", kid)
+			}
+		}
+
+		return key, nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExternalIdToken, err)
+	}
+
+	if strings.TrimRight(claims.Issuer, "/") != provider.Issuer || claims.Subject == "" {
+		return nil, ErrExternalIdToken
+	}
+
+	found := false
+	for _, aud := range claims.Audience {
+		if aud == provider.ClientId {
+			found = true
+		}
+	}
+
+	if !found || (len(claims.Audience) > 1 && claims.AuthorizedParty != provider.ClientId) {
+		return nil, ErrExternalIdToken
+	}
+
+	if nonce == "" || claims.Nonce != nonce {
+		return nil, ErrExternalIdToken
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,308 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/database/databasetest"
+	"github.com/yosikez/crudAuth/model"
+)
+
+const testClientId = "crudauth"
+
+// fakeProvider is an openid connect provider that only serves discovery and
+// its keys, the tokens are signed by the test.
+type fakeProvider struct {
+	server *httptest.Server
+
+	mu         sync.Mutex
+	keys       map[string]*ecdsa.PrivateKey
+	jwksServed int
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+
+	p := &fakeProvider{keys: map[string]*ecdsa.PrivateKey{}}
+	p.addKey(t, "key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		p.jwksServed++
+
+		keys := []JWK{}
+		for kid, key := range p.keys {
+			keys = append(keys, JWK{
+				Kty: "EC",
+				Kid: kid,
+				Alg: "ES256",
+				Use: "sig",
+				Crv: "P-256",
+				X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+				Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+			})
+		}
+
+		json.NewEncoder(w).Encode(map[string][]JWK{"keys": keys})
+	})
+
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+
+	return p
+}
+
+func (p *fakeProvider) addKey(t *testing.T, kid string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.mu.Lock()
+	p.keys[kid] = key
+	p.mu.Unlock()
+}
+
+func (p *fakeProvider) served() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.jwksServed
+}
+
+func (p *fakeProvider) config() *config.ExternalProvider {
+	return &config.ExternalProvider{Name: "fake", Issuer: p.server.URL, ClientId: testClientId}
+}
+
+func (p *fakeProvider) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+
+	p.mu.Lock()
+	key := p.keys[kid]
+	p.mu.Unlock()
+
+	// an unpublished kid is signed with a key the provider does not serve
+	if key == nil {
+		var err error
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = kid
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+func (p *fakeProvider) claims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":   p.server.URL,
+		"sub":   "subject-1",
+		"aud":   testClientId,
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": "nonce-1",
+	}
+}
+
+func (p *fakeProvider) metadata(t *testing.T) *externalMetadata {
+	t.Helper()
+
+	metadata, err := providerMetadata(p.config(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return metadata
+}
+
+func TestVerifyExternalIdToken(t *testing.T) {
+	provider := newFakeProvider(t)
+	metadata := provider.metadata(t)
+
+	tests := []struct {
+		name   string
+		modify func(claims jwt.MapClaims)
+		nonce  string
+		valid  bool
+	}{
+		{"valid", func(claims jwt.MapClaims) {}, "nonce-1", true},
+		{"other issuer", func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example.com" }, "nonce-1", false},
+		{"no subject", func(claims jwt.MapClaims) { delete(claims, "sub") }, "nonce-1", false},
+		{"other audience", func(claims jwt.MapClaims) { claims["aud"] = "someone-else" }, "nonce-1", false},
+		{"audience list with azp", func(claims jwt.MapClaims) {
+			claims["aud"] = []string{testClientId, "someone-else"}
+			claims["azp"] = testClientId
+		}, "nonce-1", true},
+		{"audience list without azp", func(claims jwt.MapClaims) {
+			claims["aud"] = []string{testClientId, "someone-else"}
+		}, "nonce-1", false},
+		{"audience list with other azp", func(claims jwt.MapClaims) {
+			claims["aud"] = []string{testClientId, "someone-else"}
+			claims["azp"] = "someone-else"
+		}, "nonce-1", false},
+		{"other nonce", func(claims jwt.MapClaims) { claims["nonce"] = "nonce-2" }, "nonce-1", false},
+		{"no nonce expected", func(claims jwt.MapClaims) { delete(claims, "nonce") }, "", false},
+		{"expired", func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Minute).Unix() }, "nonce-1", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := provider.claims()
+			test.modify(claims)
+
+			_, err := verifyExternalIdToken(provider.config(), metadata, provider.sign(t, "key-1", claims), test.nonce)
+			if test.valid && err != nil {
+				t.Fatalf("expected the id token to be accepted, got %v", err)
+			}
+
+			if !test.valid && !errors.Is(err, ErrExternalIdToken) {
+				t.Fatalf("expected ErrExternalIdToken, got %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyExternalIdTokenRejectsSymmetricAndNone(t *testing.T) {
+	provider := newFakeProvider(t)
+	metadata := provider.metadata(t)
+
+	// the public key as hmac secret is the classic confusion attack
+	hmacToken := jwt.NewWithClaims(jwt.SigningMethodHS256, provider.claims())
+	hmacToken.Header["kid"] = "key-1"
+
+	hmacSigned, err := hmacToken.SignedString([]byte(provider.server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noneToken := jwt.NewWithClaims(jwt.SigningMethodNone, provider.claims())
+	noneToken.Header["kid"] = "key-1"
+
+	noneSigned, err := noneToken.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, idToken := range map[string]string{"HS256": hmacSigned, "none": noneSigned} {
+		if _, err := verifyExternalIdToken(provider.config(), metadata, idToken, "nonce-1"); !errors.Is(err, ErrExternalIdToken) {
+			t.Errorf("%s: expected ErrExternalIdToken, got %v", name, err)
+		}
+	}
+}
+
+func TestVerifyExternalIdTokenRefreshesKeysOnUnknownKid(t *testing.T) {
+	provider := newFakeProvider(t)
+	metadata := provider.metadata(t)
+
+	// the provider rotates after its keys were cached
+	provider.addKey(t, "key-2")
+
+	if _, err := verifyExternalIdToken(provider.config(), metadata, provider.sign(t, "key-2", provider.claims()), "nonce-1"); err != nil {
+		t.Fatalf("expected the rotated key to be fetched, got %v", err)
+	}
+
+	if served := provider.served(); served != 2 {
+		t.Fatalf("expected the keys to be fetched again once, they were served %d times", served)
+	}
+
+	if _, err := verifyExternalIdToken(provider.config(), metadata, provider.sign(t, "key-3", provider.claims()), "nonce-1"); !errors.Is(err, ErrExternalIdToken) {
+		t.Fatalf("expected a kid the provider does not publish to be rejected, got %v", err)
+	}
+}
+
+func createExternalTestUser(t *testing.T, username string, emailVerified bool) *model.User {
+	t.Helper()
+
+	user := createTestUser(t, username)
+
+	if err := database.DB.Model(user).Update("email_verified", emailVerified).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func countExternalIdentities(t *testing.T) int64 {
+	t.Helper()
+
+	var count int64
+	if err := database.DB.Model(&model.ExternalIdentity{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return count
+}
+
+func TestExternalUserLinksOnlyVerifiedEmails(t *testing.T) {
+	databasetest.Open(t)
+
+	verified := createExternalTestUser(t, "alice", true)
+	createExternalTestUser(t, "bob", false)
+
+	if _, err := externalUser("fake", "subject-1", "alice@example.com", false, "", false); !errors.Is(err, ErrExternalEmailNotVerified) {
+		t.Fatalf("expected an email the provider did not verify to be refused, got %v", err)
+	}
+
+	if _, err := externalUser("fake", "subject-2", "bob@example.com", true, "", true); !errors.Is(err, ErrExternalAccountNotLinked) {
+		t.Fatalf("expected an account with an unverified email to stay unlinked, got %v", err)
+	}
+
+	if _, err := externalUser("fake", "subject-3", "carol@example.com", true, "", false); !errors.Is(err, ErrExternalAccountNotLinked) {
+		t.Fatalf("expected no account to be created without autoCreate, got %v", err)
+	}
+
+	if count := countExternalIdentities(t); count != 0 {
+		t.Fatalf("expected no identity to be linked, got %d", count)
+	}
+
+	user, err := externalUser("fake", "subject-1", "ALICE@example.com", true, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.Id != verified.Id {
+		t.Fatalf("expected the identity to be linked to alice, got user %d", user.Id)
+	}
+
+	// once linked the subject decides, not the email it comes with
+	user, err = externalUser("fake", "subject-1", "", false, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.Id != verified.Id {
+		t.Fatalf("expected the linked identity to log in as alice, got user %d", user.Id)
+	}
+}
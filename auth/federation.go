@@ -0,0 +1,292 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/model"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrExternalProviderNotFound = errors.New("unknown login provider")
+	ErrExternalLoginState       = errors.New("invalid or expired login state")
+	ErrExternalEmailNotVerified = errors.New("the login provider did not confirm a verified email")
+	ErrExternalAccountNotLinked = errors.New("no account is linked to this identity")
+)
+
+var federation = &config.Federation{}
+
+var usernameCharacters = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+func InitFederation(cfg *config.Federation) {
+	federation = cfg
+}
+
+// ExternalProviders returns the names of the configured providers.
+func ExternalProviders() []string {
+	names := make([]string, 0, len(federation.Providers))
+	for _, provider := range federation.Providers {
+		names = append(names, provider.Name)
+	}
+
+	return names
+}
+
+func randomURLString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// BeginExternalLogin returns the url to send the user to and the state that
+// has to come back with the callback. The pkce verifier and nonce stay on
+// the server.
+func BeginExternalLogin(name, redirectURI string, scopes []string) (string, string, error) {
+	provider := federation.Provider(name)
+	if provider == nil {
+		return "", "", ErrExternalProviderNotFound
+	}
+
+	metadata, err := providerMetadata(provider, false)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomURLString()
+	if err != nil {
+		return "", "", err
+	}
+
+	nonce, err := randomURLString()
+	if err != nil {
+		return "", "", err
+	}
+
+	verifier, err := randomURLString()
+	if err != nil {
+		return "", "", err
+	}
+
+	// expired logins are cleaned up whenever a new one starts
+	if err := database.DB.Where("expires_at < ?", time.Now()).Delete(&model.ExternalLoginState{}).Error; err != nil {
+		return "", "", err
+	}
+
+	err = database.DB.Create(&model.ExternalLoginState{
+		StateHash:    HashToken(state),
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		Scope:        FormatScope(scopes),
+		ExpiresAt:    time.Now().Add(config.ExternalLoginDuration),
+	}).Error
+
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", "", ErrExternalProvider
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", provider.ClientId)
+	query.Set("redirect_uri", redirectURI)
+	query.Set("scope", strings.Join(provider.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", config.PkceMethodS256)
+	authURL.RawQuery = query.Encode()
+
+	return authURL.String(), state, nil
+}
+
+// takeExternalLoginState loads and deletes a login, so a state can only be
+// used once.
+func takeExternalLoginState(name, state string) (*model.ExternalLoginState, error) {
+	var loginState model.ExternalLoginState
+
+	err := database.DB.Where("state_hash = ? AND provider = ? AND expires_at > ?", HashToken(state), name, time.Now()).First(&loginState).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrExternalLoginState
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := database.DB.Where("state_hash = ?", loginState.StateHash).Delete(&model.ExternalLoginState{})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, ErrExternalLoginState
+	}
+
+	return &loginState, nil
+}
+
+// CompleteExternalLogin handles the callback from the provider and returns
+// the user and the scopes asked for when the login began.
+func CompleteExternalLogin(name, state, code, redirectURI string) (*model.User, []string, error) {
+	provider := federation.Provider(name)
+	if provider == nil {
+		return nil, nil, ErrExternalProviderNotFound
+	}
+
+	loginState, err := takeExternalLoginState(name, state)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	claims, err := exchangeExternalCode(provider, code, redirectURI, loginState.CodeVerifier, loginState.Nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := externalUser(provider.Name, claims.Subject, claims.Email, claims.emailVerified(), claims.PreferredUsername, federation.AutoCreateUsers)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := CheckUserStatus(user); err != nil {
+		return nil, nil, err
+	}
+
+	return user, strings.Fields(loginState.Scope), nil
+}
+
+// externalUser finds the user of an external identity. An identity seen for
+// the first time is linked to the account with the same email when both
+// sides verified it, otherwise a new account is created if autoCreate is set.
+func externalUser(provider, subject, email string, emailVerified bool, preferredUsername string, autoCreate bool) (*model.User, error) {
+	var identity model.ExternalIdentity
+
+	err := database.DB.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error
+	if err == nil {
+		return LoadUser(identity.UserId)
+	}
+
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// an unverified email says nothing about who owns it, linking on it
+	// would let anyone take over the account with that address
+	if email == "" || !emailVerified {
+		return nil, ErrExternalEmailNotVerified
+	}
+
+	var user model.User
+	created := false
+
+	err = database.DB.Where("LOWER(email) = LOWER(?)", email).First(&user).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if err == nil {
+		// someone may have registered the address without owning it
+		if !user.EmailVerified {
+			return nil, ErrExternalAccountNotLinked
+		}
+	} else {
+		if !autoCreate {
+			return nil, ErrExternalAccountNotLinked
+		}
+
+		if err := createExternalUser(&user, email, preferredUsername); err != nil {
+			return nil, err
+		}
+
+		created = true
+	}
+
+	err = database.DB.Create(&model.ExternalIdentity{
+		UserId:      user.Id,
+		Provider:    provider,
+		Subject:     subject,
+		Email:       email,
+		CreatedUser: created,
+	}).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return LoadUser(user.Id)
+}
+
+// createExternalUser creates an account for an external identity. It gets
+// a random password nobody knows, the user can set one with the password
+// reset.
+func createExternalUser(user *model.User, email, preferredUsername string) error {
+	base := preferredUsername
+	if base == "" {
+		base = strings.SplitN(email, "@", 2)[0]
+	}
+
+	base = usernameCharacters.ReplaceAllString(base, "")
+	if base == "" {
+		base = "user"
+	}
+
+	password, err := randomURLString()
+	if err != nil {
+		return err
+	}
+
+	username := base
+	for attempt := 0; ; attempt++ {
+		var count int64
+		if err := database.DB.Model(&model.User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+			return err
+		}
+
+		if count == 0 {
+			break
+		}
+
+		if attempt == 5 {
+			return errors.New("failed to find a free username")
+		}
+
+		suffix, err := newTokenId()
+		if err != nil {
+			return err
+		}
+
+		username = base + "-" + suffix[:6]
+	}
+
+	*user = model.User{
+		Username:      username,
+		Email:         email,
+		Password:      password,
+		EmailVerified: true,
+	}
+
+	if err := database.DB.Create(user).Error; err != nil {
+		return err
+	}
+
+	return AssignRoles(user, []string{config.DefaultRole})
+}
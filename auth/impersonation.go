@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/model"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrImpersonateSelf         = errors.New("cannot impersonate your own account")
+	ErrImpersonationNotAllowed = errors.New("cannot impersonate a user with permissions you do not have")
+	ErrImpersonationNotFound   = errors.New("impersonation not found")
+)
+
+// Impersonate returns a short lived access token that acts as target, with
+// the actor in its act claim, and records it in the audit log. There is no
+// session and no refresh token. The actor must hold every permission of the
+// target, so impersonation can not be used to gain permissions.
+func Impersonate(actor, target *model.User, reason, ipAddress, userAgent string) (string, *model.ImpersonationLog, error) {
+	if actor.Id == target.Id {
+		return "", nil, ErrImpersonateSelf
+	}
+
+	if target.IsDisabled {
+		return "", nil, ErrUserDisabled
+	}
+
+	actorPermissions := map[string]bool{}
+	for _, permission := range actor.PermissionNames() {
+		actorPermissions[permission] = true
+	}
+
+	for _, permission := range target.PermissionNames() {
+		if !actorPermissions[permission] {
+			return "", nil, ErrImpersonationNotAllowed
+		}
+	}
+
+	jti, err := newTokenId()
+	if err != nil {
+		return "", nil, err
+	}
+
+	expiresAt := time.Now().Add(config.ImpersonationDuration)
+	claims := config.Claims{
+		Id:            target.Id,
+		Username:      target.Username,
+		Email:         target.Email,
+		EmailVerified: target.EmailVerified,
+		Roles:         target.RoleNames(),
+		Permissions:   target.PermissionNames(),
+		Scope:         FormatScope(config.ImpersonationScopes),
+		Act: &config.ActorClaim{
+			Subject:  strconv.Itoa(int(actor.Id)),
+			Username: actor.Username,
+		},
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			ExpiresAt: expiresAt.Unix(),
+			Issuer:    config.RefreshTokenIssuer,
+			Audience:  config.AccessTokenAudience,
+		},
+	}
+
+	entry := &model.ImpersonationLog{
+		ActorId:       actor.Id,
+		ActorUsername: actor.Username,
+		UserId:        target.Id,
+		Username:      target.Username,
+		Reason:        reason,
+		TokenId:       jti,
+		IpAddress:     ipAddress,
+		UserAgent:     userAgent,
+		ExpiresAt:     expiresAt,
+	}
+
+	// logged before the token exists, a token is never handed out without
+	// its audit entry
+	if err := database.DB.Create(entry).Error; err != nil {
+		return "", nil, err
+	}
+
+	token, err := SignToken(claims)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, entry, nil
+}
+
+// RevokeImpersonation puts the token of an impersonation on the revocation
+// list, there is no session to end. Revoking it again is not an error, the
+// entry keeps the time it was first revoked.
+func RevokeImpersonation(id uint) (*model.ImpersonationLog, error) {
+	var entry model.ImpersonationLog
+
+	err := database.DB.Where("id = ?", id).First(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrImpersonationNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := RevokeAccessToken(entry.TokenId, entry.UserId, entry.ExpiresAt.Unix()); err != nil {
+		return nil, err
+	}
+
+	if entry.RevokedAt == nil {
+		now := time.Now()
+
+		if err := database.DB.Model(&entry).Where("revoked_at IS NULL").Update("revoked_at", now).Error; err != nil {
+			return nil, err
+		}
+
+		entry.RevokedAt = &now
+	}
+
+	return &entry, nil
+}
@@ -0,0 +1,361 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/model"
+)
+
+type signingKey struct {
+	kid         string
+	method      jwt.SigningMethod
+	private     crypto.Signer
+	activatesAt time.Time
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type keyRing struct {
+	mu     sync.RWMutex
+	cfg    *config.JWT
+	keys   map[string]*signingKey
+	active *signingKey
+	// lastMissReload is when an unknown kid last caused a reload
+	lastMissReload time.Time
+}
+
+var keys = &keyRing{keys: map[string]*signingKey{}}
+
+// InitKeyRing makes sure a signing key exists and loads every key that can
+// still verify tokens. It must be called before any token is issued.
+func InitKeyRing(cfg *config.JWT) error {
+	keys.mu.Lock()
+	keys.cfg = cfg
+	keys.mu.Unlock()
+
+	return RotateSigningKeys()
+}
+
+// StartKeyRotation checks every interval whether the next signing key has to
+// be published, and picks up keys created by other instances. It blocks, so
+// run it in its own goroutine.
+func StartKeyRotation(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := RotateSigningKeys(); err != nil {
+			log.Printf("failed to rotate signing keys : %v", err)
+		}
+	}
+}
+
+func RotateSigningKeys() error {
+	keys.mu.RLock()
+	cfg := keys.cfg
+	keys.mu.RUnlock()
+
+	if cfg == nil {
+		return errors.New("key ring is not initialized")
+	}
+
+	now := time.Now()
+
+	if err := database.DB.Where("expires_at < ?", now).Delete(&model.SigningKey{}).Error; err != nil {
+		return err
+	}
+
+	var latest model.SigningKey
+	result := database.DB.Order("activates_at desc").Limit(1).Find(&latest)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		if _, err := createSigningKey(cfg.SigningAlgorithm, now); err != nil {
+			return err
+		}
+	} else if nextActivation := latest.ActivatesAt.Add(cfg.KeyRotationPeriod); now.After(nextActivation.Add(-config.SigningKeyPublishAhead)) {
+		if nextActivation.Before(now) {
+			nextActivation = now
+		}
+
+		if _, err := createSigningKey(cfg.SigningAlgorithm, nextActivation); err != nil {
+			return err
+		}
+
+		// tokens signed by the previous key stay verifiable until they expire
+		expiresAt := nextActivation.Add(config.RefreshTokenDuration)
+		if err := database.DB.Model(&latest).Update("expires_at", expiresAt).Error; err != nil {
+			return err
+		}
+	}
+
+	return reloadKeys()
+}
+
+func createSigningKey(algorithm string, activatesAt time.Time) (*model.SigningKey, error) {
+	var private crypto.Signer
+	var err error
+
+	switch algorithm {
+	case "RS256":
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "EdDSA":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	kid, err := newTokenId()
+	if err != nil {
+		return nil, err
+	}
+
+	key := model.SigningKey{
+		Kid:         kid,
+		Algorithm:   algorithm,
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		ActivatesAt: activatesAt,
+	}
+
+	if err := database.DB.Create(&key).Error; err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+func reloadKeys() error {
+	var stored []model.SigningKey
+
+	if err := database.DB.Order("activates_at asc").Find(&stored).Error; err != nil {
+		return err
+	}
+
+	loaded := make(map[string]*signingKey, len(stored))
+	var active *signingKey
+	now := time.Now()
+
+	for _, k := range stored {
+		key, err := parseSigningKey(k)
+		if err != nil {
+			return fmt.Errorf("failed to load signing key %s : %v", k.Kid, err)
+		}
+
+		loaded[key.kid] = key
+
+		if !key.activatesAt.After(now) {
+			active = key
+		}
+	}
+
+	if active == nil {
+		return errors.New("no active signing key")
+	}
+
+	keys.mu.Lock()
+	keys.keys = loaded
+	keys.active = active
+	keys.mu.Unlock()
+
+	return nil
+}
+
+func parseSigningKey(k model.SigningKey) (*signingKey, error) {
+	block, _ := pem.Decode([]byte(k.PrivateKey))
+	if block == nil {
+		return nil, errors.New("invalid pem block")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	private, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("key cannot sign")
+	}
+
+	method := jwt.GetSigningMethod(k.Algorithm)
+	if method == nil {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", k.Algorithm)
+	}
+
+	return &signingKey{
+		kid:         k.Kid,
+		method:      method,
+		private:     private,
+		activatesAt: k.ActivatesAt,
+	}, nil
+}
+
+// SignToken signs claims with the active key and sets the kid header.
+func SignToken(claims jwt.Claims) (string, error) {
+	keys.mu.RLock()
+	active := keys.active
+	keys.mu.RUnlock()
+
+	if active == nil {
+		return "", errors.New("no active signing key")
+	}
+
+	token := jwt.NewWithClaims(active.method, claims)
+	token.Header["kid"] = active.kid
+
+	return token.SignedString(active.private)
+}
+
+// ParseToken verifies a token against the key named by its kid header.
+func ParseToken(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, verificationKey)
+}
+
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no kid")
+	}
+
+	key := lookupKey(kid)
+	if key == nil {
+		// the key may have been created by another instance since our last reload
+		if err := reloadKeysOnMiss(); err != nil {
+			return nil, err
+		}
+
+		if key = lookupKey(kid); key == nil {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+	}
+
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %q", token.Method.Alg())
+	}
+
+	return key.private.Public(), nil
+}
+
+// reloadKeysOnMiss reloads at most once per SigningKeyMissReloadInterval,
+// the kid comes from the token, so made up kids must not turn every request
+// into a query on the signing keys.
+func reloadKeysOnMiss() error {
+	keys.mu.Lock()
+	if time.Since(keys.lastMissReload) < config.SigningKeyMissReloadInterval {
+		keys.mu.Unlock()
+		return nil
+	}
+
+	keys.lastMissReload = time.Now()
+	keys.mu.Unlock()
+
+	return reloadKeys()
+}
+
+func lookupKey(kid string) *signingKey {
+	keys.mu.RLock()
+	defer keys.mu.RUnlock()
+
+	return keys.keys[kid]
+}
+
+// SigningAlgorithms lists the algorithms of the keys that can verify tokens,
+// for the discovery document.
+func SigningAlgorithms() []string {
+	keys.mu.RLock()
+	defer keys.mu.RUnlock()
+
+	seen := map[string]bool{}
+	algorithms := []string{}
+
+	for _, key := range keys.keys {
+		if alg := key.method.Alg(); !seen[alg] {
+			seen[alg] = true
+			algorithms = append(algorithms, alg)
+		}
+	}
+
+	sort.Strings(algorithms)
+
+	return algorithms
+}
+
+// JWKS returns the public half of every key that can verify tokens,
+// including the next key if it has already been published.
+func JWKS() ([]JWK, error) {
+	keys.mu.RLock()
+	defer keys.mu.RUnlock()
+
+	jwks := make([]JWK, 0, len(keys.keys))
+
+	for _, key := range keys.keys {
+		jwk := JWK{
+			Kid: key.kid,
+			Alg: key.method.Alg(),
+			Use: "sig",
+		}
+
+		switch public := key.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (public.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = public.Curve.Params().Name
+			jwk.X = base64.RawURLEncoding.EncodeToString(public.X.FillBytes(make([]byte, size)))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(public.Y.FillBytes(make([]byte, size)))
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			return nil, fmt.Errorf("unsupported public key type for kid %s", key.kid)
+		}
+
+		jwks = append(jwks, jwk)
+	}
+
+	return jwks, nil
+}
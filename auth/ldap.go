@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/model"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrLdapEmailInUse    = errors.New("the directory email belongs to another account")
+	ErrLdapEmailRequired = errors.New("the directory entry has no email")
+)
+
+// LdapAuthenticator binds as the user to check the password and keeps a
+// shadow user with auth_source ldap in sync with the directory entry.
+type LdapAuthenticator struct {
+	cfg *config.Ldap
+	// dial is replaced in tests by a stand-in for the directory
+	dial func() (ldap.Client, error)
+}
+
+func NewLdapAuthenticator(cfg *config.Ldap) *LdapAuthenticator {
+	a := &LdapAuthenticator{cfg: cfg}
+	a.dial = a.connect
+
+	return a
+}
+
+func (a *LdapAuthenticator) connect() (ldap.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: a.cfg.InsecureSkipVerify}
+	if parsed, err := url.Parse(a.cfg.URL); err == nil {
+		tlsConfig.ServerName = parsed.Hostname()
+	}
+
+	conn, err := ldap.DialURL(a.cfg.URL, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetTimeout(config.LdapTimeout)
+
+	if a.cfg.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// ldapEntry is what the authenticator takes from the directory.
+type ldapEntry struct {
+	username string
+	email    string
+	groups   []string
+}
+
+func (a *LdapAuthenticator) Authenticate(username, password string) (*model.User, error) {
+	// a simple bind with an empty password is an unauthenticated bind, which
+	// many servers accept for any dn
+	if username == "" || password == "" {
+		return nil, ErrUnknownUser
+	}
+
+	var existing model.User
+
+	err := database.DB.Where("username = ?", username).First(&existing).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// a directory entry with the name of a local account must not take it over
+	if err == nil && existing.AuthSource != config.AuthSourceLdap {
+		return nil, ErrUnknownUser
+	}
+
+	entry, err := a.bind(username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.syncUser(entry)
+}
+
+// bind looks the user up and binds with its dn and password. Everything that
+// goes wrong before the user's own bind is the directory's problem and comes
+// back as ErrAuthenticatorUnavailable.
+func (a *LdapAuthenticator) bind(username, password string) (*ldapEntry, error) {
+	conn, err := a.dial()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAuthenticatorUnavailable, err)
+	}
+	defer conn.Close()
+
+	if a.cfg.BindDN != "" {
+		if err := conn.Bind(a.cfg.BindDN, a.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("%w: service account bind failed: %v", ErrAuthenticatorUnavailable, err)
+		}
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		a.cfg.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(config.LdapTimeout.Seconds()), false,
+		fmt.Sprintf(a.cfg.UserFilter, ldap.EscapeFilter(username)),
+		[]string{a.cfg.UsernameAttribute, a.cfg.EmailAttribute, a.cfg.GroupAttribute},
+		nil,
+	))
+
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("%w: %v", ErrAuthenticatorUnavailable, err)
+	}
+
+	if result == nil || len(result.Entries) == 0 {
+		return nil, ErrUnknownUser
+	}
+
+	if len(result.Entries) > 1 {
+		return nil, fmt.Errorf("ldap filter matched more than one entry for %q", username)
+	}
+
+	found := result.Entries[0]
+
+	if err := conn.Bind(found.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidPassword
+		}
+
+		return nil, fmt.Errorf("%w: %v", ErrAuthenticatorUnavailable, err)
+	}
+
+	entry := &ldapEntry{
+		username: found.GetAttributeValue(a.cfg.UsernameAttribute),
+		email:    found.GetAttributeValue(a.cfg.EmailAttribute),
+		groups:   found.GetAttributeValues(a.cfg.GroupAttribute),
+	}
+
+	// the filter may match case insensitively, the shadow user keeps the
+	// name that was typed only when the directory has none
+	if entry.username == "" {
+		entry.username = username
+	}
+
+	// every user needs a unique email
+	if entry.email == "" {
+		return nil, ErrLdapEmailRequired
+	}
+
+	return entry, nil
+}
+
+// syncUser creates the shadow user on first login and updates its email and
+// roles from the directory afterwards.
+func (a *LdapAuthenticator) syncUser(entry *ldapEntry) (*model.User, error) {
+	var user model.User
+
+	err := database.DB.Where("username = ? AND auth_source = ?", entry.username, config.AuthSourceLdap).First(&user).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var count int64
+	if err := database.DB.Model(&model.User{}).Where("LOWER(email) = LOWER(?) AND id <> ?", entry.email, user.Id).Count(&count).Error; err != nil {
+		return nil, err
+	}
+
+	if count > 0 {
+		return nil, ErrLdapEmailInUse
+	}
+
+	roles := mapRoles(a.cfg.GroupRoles, entry.groups)
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// the password stays in the directory, the shadow gets a random one
+		// that no authenticator accepts
+		password, err := randomURLString()
+		if err != nil {
+			return nil, err
+		}
+
+		user = model.User{
+			Username:      entry.username,
+			Email:         entry.email,
+			Password:      password,
+			AuthSource:    config.AuthSourceLdap,
+			EmailVerified: true,
+		}
+
+		if err := database.DB.Create(&user).Error; err != nil {
+			return nil, err
+		}
+
+		if roles == nil {
+			roles = []string{config.DefaultRole}
+		}
+	} else if entry.email != user.Email {
+		if err := database.DB.Model(&user).Updates(map[string]interface{}{
+			"email":          entry.email,
+			"email_verified": true,
+		}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if roles != nil {
+		if err := AssignRoles(&user, roles); err != nil {
+			return nil, err
+		}
+	}
+
+	return LoadUser(user.Id)
+}
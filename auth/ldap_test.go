@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database/databasetest"
+)
+
+const testBaseDN = "ou=people,dc=example,dc=com"
+
+// fakeDirectory answers the search and binds of the ldap authenticator from
+// memory, the methods it does not use panic through the nil ldap.Client.
+type fakeDirectory struct {
+	ldap.Client
+
+	// entries by username, passwords by dn
+	entries   map[string]*ldap.Entry
+	passwords map[string]string
+
+	filters []string
+	binds   []string
+}
+
+func newFakeDirectory() *fakeDirectory {
+	return &fakeDirectory{entries: map[string]*ldap.Entry{}, passwords: map[string]string{}}
+}
+
+func (d *fakeDirectory) add(username, email, password string, groups ...string) {
+	dn := "uid=" + username + "," + testBaseDN
+
+	d.entries[username] = ldap.NewEntry(dn, map[string][]string{
+		"uid":      {username},
+		"mail":     {email},
+		"memberOf": groups,
+	})
+	d.passwords[dn] = password
+}
+
+func (d *fakeDirectory) Close() error {
+	return nil
+}
+
+func (d *fakeDirectory) Bind(username, password string) error {
+	d.binds = append(d.binds, username)
+
+	if expected, ok := d.passwords[username]; !ok || expected != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+
+	return nil
+}
+
+func (d *fakeDirectory) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	d.filters = append(d.filters, request.Filter)
+
+	result := &ldap.SearchResult{}
+	for username, entry := range d.entries {
+		if request.Filter == "(uid="+ldap.EscapeFilter(username)+")" {
+			result.Entries = append(result.Entries, entry)
+		}
+	}
+
+	return result, nil
+}
+
+func setupLdap(t *testing.T, groupRoles map[string][]string) *fakeDirectory {
+	t.Helper()
+
+	databasetest.Open(t)
+
+	directory := newFakeDirectory()
+
+	authenticator := NewLdapAuthenticator(&config.Ldap{
+		BaseDN:            testBaseDN,
+		UserFilter:        "(uid=%s)",
+		UsernameAttribute: "uid",
+		EmailAttribute:    "mail",
+		GroupAttribute:    "memberOf",
+		GroupRoles:        groupRoles,
+	})
+	authenticator.dial = func() (ldap.Client, error) {
+		return directory, nil
+	}
+
+	SetAuthenticators(authenticator, LocalAuthenticator{})
+	t.Cleanup(func() {
+		SetAuthenticators(LocalAuthenticator{})
+	})
+
+	return directory
+}
+
+func TestLdapEscapesTheUsernameInTheFilter(t *testing.T) {
+	directory := setupLdap(t, nil)
+	directory.add("alice", "alice@example.com", "secret")
+
+	if _, err := AuthenticateUser("*)(uid=*", "secret"); !errors.Is(err, ErrUnknownUser) {
+		t.Fatalf("expected the wildcard not to match alice, got %v", err)
+	}
+
+	if expected := `(uid=\2a\29\28uid=\2a)`; len(directory.filters) != 1 || directory.filters[0] != expected {
+		t.Fatalf("expected the filter %s, got %v", expected, directory.filters)
+	}
+}
+
+func TestLdapUnknownUserFallsThroughToLocal(t *testing.T) {
+	directory := setupLdap(t, nil)
+	directory.add("bob", "bob-directory@example.com", "directory password")
+
+	local := createExternalTestUser(t, "carol", true)
+
+	user, err := AuthenticateUser("carol", "correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.Id != local.Id {
+		t.Fatalf("expected the local account, got user %d", user.Id)
+	}
+
+	// a local account is never looked up in the directory, even when an
+	// entry there has its name
+	createExternalTestUser(t, "bob", true)
+
+	if _, err := AuthenticateUser("bob", "directory password"); !errors.Is(err, ErrInvalidPassword) {
+		t.Fatalf("expected the local password to be checked, got %v", err)
+	}
+
+	if len(directory.binds) != 0 {
+		t.Fatalf("expected no bind against the directory, got %v", directory.binds)
+	}
+}
+
+func TestLdapWrongPasswordIsInvalidCredentials(t *testing.T) {
+	directory := setupLdap(t, nil)
+	directory.add("alice", "alice@example.com", "secret")
+
+	if _, err := AuthenticateUser("alice", "guess"); !errors.Is(err, ErrInvalidPassword) {
+		t.Fatalf("expected ErrInvalidPassword, got %v", err)
+	}
+}
+
+func TestLdapUnreachableIsUnavailable(t *testing.T) {
+	setupLdap(t, nil)
+
+	authenticators[0].(*LdapAuthenticator).dial = func() (ldap.Client, error) {
+		return nil, ldap.NewError(ldap.ErrorNetwork, errors.New("connection refused"))
+	}
+
+	if _, err := AuthenticateUser("alice", "secret"); !errors.Is(err, ErrAuthenticatorUnavailable) {
+		t.Fatalf("expected ErrAuthenticatorUnavailable, got %v", err)
+	}
+}
+
+func TestLdapSyncsGroupsToRoles(t *testing.T) {
+	adminsGroup := "cn=admins,ou=groups,dc=example,dc=com"
+
+	directory := setupLdap(t, map[string][]string{adminsGroup: {config.RoleAdmin}})
+	directory.add("alice", "alice@example.com", "secret", "CN=Admins,ou=groups,dc=example,dc=com")
+
+	// the last admin can not lose the role, so alice is not the only one
+	if err := AssignRoles(createExternalTestUser(t, "root", true), []string{config.RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := AuthenticateUser("alice", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.AuthSource != config.AuthSourceLdap || !user.EmailVerified {
+		t.Fatalf("expected a verified ldap shadow user, got %+v", user)
+	}
+
+	if roles := user.RoleNames(); !reflect.DeepEqual(roles, []string{config.RoleAdmin}) {
+		t.Fatalf("expected the admin role from the group, got %v", roles)
+	}
+
+	// leaving the group takes the role away on the next login
+	directory.add("alice", "alice@example.com", "secret")
+
+	user, err = AuthenticateUser("alice", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if roles := user.RoleNames(); !reflect.DeepEqual(roles, []string{config.DefaultRole}) {
+		t.Fatalf("expected only the default role, got %v", roles)
+	}
+}
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrAccountLocked  = errors.New("account is temporarily locked after too many failed logins")
+	ErrLoginThrottled = errors.New("too many failed logins, try again later")
+)
+
+func usernameKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func loginDelay(count int) time.Duration {
+	if count < config.LoginDelayAfter {
+		return 0
+	}
+
+	delay := config.LoginDelayBase
+	for i := config.LoginDelayAfter; i < count && delay < config.LoginDelayMax; i++ {
+		delay *= 2
+	}
+
+	if delay > config.LoginDelayMax {
+		delay = config.LoginDelayMax
+	}
+
+	return delay
+}
+
+// lockLoginFailure returns the row of key, created if needed, locked until
+// the transaction ends. Counts from outside the window or from an expired
+// lockout are dropped.
+func lockLoginFailure(tx *gorm.DB, key string, now time.Time) (*model.LoginFailure, error) {
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.LoginFailure{Key: key, LastFailedAt: now}).Error; err != nil {
+		return nil, err
+	}
+
+	var failure model.LoginFailure
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&failure).Error; err != nil {
+		return nil, err
+	}
+
+	if now.Sub(failure.LastFailedAt) > config.LoginFailureWindow ||
+		(failure.LockedUntil != nil && !failure.LockedUntil.After(now)) {
+		failure.Count = 0
+		failure.LockedUntil = nil
+	}
+
+	return &failure, nil
+}
+
+// CheckLoginAllowed returns ErrAccountLocked or ErrLoginThrottled, together
+// with how long the client has to wait, when the username or the ip has too
+// many recent failures. Usernames without an account are tracked the same
+// way so the answer does not reveal which accounts exist.
+//
+// An allowed attempt is counted as a failure right away, in the transaction
+// that checked the counts, so parallel requests can not all pass the check
+// before the first of them failed. ForgiveLoginAttempt takes it back once
+// the credentials turn out to be right.
+func CheckLoginAllowed(username, ip string) (time.Duration, error) {
+	return checkFailures(usernameKey(username), ipKey(ip))
+}
+
+// checkFailures is CheckLoginAllowed for any set of keys, the attempt is
+// counted on all of them when none refuses it.
+func checkFailures(keys ...string) (time.Duration, error) {
+	var wait time.Duration
+	var refused error
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		failures := make([]*model.LoginFailure, 0, len(keys))
+
+		// always locked in the order of keys, so two checks can not deadlock
+		for _, key := range keys {
+			failure, err := lockLoginFailure(tx, key, now)
+			if err != nil {
+				return err
+			}
+
+			if failure.LockedUntil != nil {
+				wait, refused = failure.LockedUntil.Sub(now), ErrAccountLocked
+				return nil
+			}
+
+			if next := failure.LastFailedAt.Add(loginDelay(failure.Count)); next.After(now) && next.Sub(now) > wait {
+				wait = next.Sub(now)
+			}
+
+			failures = append(failures, failure)
+		}
+
+		if wait > 0 {
+			refused = ErrLoginThrottled
+			return nil
+		}
+
+		for _, failure := range failures {
+			failure.Count++
+			failure.LastFailedAt = now
+
+			if err := tx.Save(failure).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return wait, refused
+}
+
+// RecordLoginFailure is called when an attempt CheckLoginAllowed let through
+// failed, the attempt is already counted. When the username reached the
+// lockout threshold it is locked and the time it is locked until is returned.
+// Only usernames get locked, locking an ip would let one client lock out
+// everyone behind the same nat.
+func RecordLoginFailure(cfg *config.LoginProtection, username string) (*time.Time, error) {
+	var lockedUntil *time.Time
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		failure, err := lockLoginFailure(tx, usernameKey(username), now)
+		if err != nil {
+			return err
+		}
+
+		if failure.LockedUntil != nil || failure.Count < cfg.LockoutThreshold {
+			return nil
+		}
+
+		until := now.Add(cfg.LockoutDuration)
+		failure.LockedUntil = &until
+		failure.Count = 0
+		lockedUntil = &until
+
+		return tx.Save(failure).Error
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return lockedUntil, nil
+}
+
+// ForgiveLoginAttempt takes back the failure CheckLoginAllowed counted for an
+// attempt whose credentials were right.
+func ForgiveLoginAttempt(username, ip string) error {
+	return forgiveFailures(usernameKey(username), ipKey(ip))
+}
+
+func forgiveFailures(keys ...string) error {
+	for _, key := range keys {
+		if err := database.DB.Model(&model.LoginFailure{}).
+			Where("key = ? AND count > 0", key).
+			Update("count", gorm.Expr("count - 1")).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ResetLoginFailures forgets the failures of a username after a successful
+// login or an admin unlock. Failures per ip are left to expire on their own,
+// otherwise logging into one account would reset guessing on the others.
+func ResetLoginFailures(username string) error {
+	return database.DB.Where("key = ?", usernameKey(username)).Delete(&model.LoginFailure{}).Error
+}
+
+// PurgeLoginFailures removes rows that no longer count. Every ip and every
+// username tried gets a row, accounts or not, so without this the table
+// would only grow.
+func PurgeLoginFailures() error {
+	now := time.Now()
+
+	return database.DB.
+		Where("last_failed_at < ? AND (locked_until IS NULL OR locked_until < ?)", now.Add(-config.LoginFailureWindow), now).
+		Delete(&model.LoginFailure{}).Error
+}
+
+// StartLoginFailurePurger runs PurgeLoginFailures every interval. It blocks,
+// so run it in its own goroutine.
+func StartLoginFailurePurger(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := PurgeLoginFailures(); err != nil {
+			log.Printf("failed to purge login failures : %v", err)
+		}
+	}
+}
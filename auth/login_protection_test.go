@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/database/databasetest"
+	"github.com/yosikez/crudAuth/model"
+)
+
+var testLoginProtection = &config.LoginProtection{LockoutThreshold: 3, LockoutDuration: time.Minute}
+
+// failLogins runs n attempts with the wrong password, each from another ip
+// so only the username counts up.
+func failLogins(t *testing.T, username string, n int) *time.Time {
+	t.Helper()
+
+	var lockedUntil *time.Time
+
+	for i := 0; i < n; i++ {
+		if _, err := CheckLoginAllowed(username, fmt.Sprintf("10.0.0.%d", i)); err != nil {
+			t.Fatalf("attempt %d: expected to be allowed, got %v", i+1, err)
+		}
+
+		var err error
+		if lockedUntil, err = RecordLoginFailure(testLoginProtection, username); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return lockedUntil
+}
+
+func TestLoginLockoutAtThreshold(t *testing.T) {
+	databasetest.Open(t)
+
+	if lockedUntil := failLogins(t, "alice", 2); lockedUntil != nil {
+		t.Fatalf("expected no lockout below the threshold, locked until %v", lockedUntil)
+	}
+
+	lockedUntil := failLogins(t, "alice", 1)
+	if lockedUntil == nil {
+		t.Fatal("expected the account to be locked at the threshold")
+	}
+
+	// the lockout is per username, whatever the case and the ip
+	wait, err := CheckLoginAllowed("ALICE", "10.0.1.1")
+	if !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("expected ErrAccountLocked, got %v", err)
+	}
+
+	if wait <= 0 || wait > testLoginProtection.LockoutDuration {
+		t.Fatalf("expected to wait for the lockout, got %v", wait)
+	}
+
+	if _, err := CheckLoginAllowed("bob", "10.0.1.1"); err != nil {
+		t.Fatalf("expected other accounts to stay open, got %v", err)
+	}
+}
+
+func TestLoginUnlock(t *testing.T) {
+	databasetest.Open(t)
+
+	if failLogins(t, "alice", 3) == nil {
+		t.Fatal("expected the account to be locked")
+	}
+
+	// an admin unlock
+	if err := ResetLoginFailures("alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CheckLoginAllowed("alice", "10.0.1.1"); err != nil {
+		t.Fatalf("expected the unlocked account to be allowed, got %v", err)
+	}
+
+	if err := ResetLoginFailures("alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if failLogins(t, "alice", 3) == nil {
+		t.Fatal("expected the account to be locked again")
+	}
+
+	// the lockout running out
+	if err := database.DB.Model(&model.LoginFailure{}).Where("key = ?", usernameKey("alice")).
+		Update("locked_until", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CheckLoginAllowed("alice", "10.0.1.1"); err != nil {
+		t.Fatalf("expected the account to be allowed after the lockout, got %v", err)
+	}
+
+	// and the count starts over instead of locking on the next failure
+	if lockedUntil, err := RecordLoginFailure(testLoginProtection, "alice"); err != nil || lockedUntil != nil {
+		t.Fatalf("expected the count to start over, locked until %v, %v", lockedUntil, err)
+	}
+}
+
+func TestLoginRightPasswordsAreForgiven(t *testing.T) {
+	databasetest.Open(t)
+
+	for i := 0; i < config.LoginDelayAfter+testLoginProtection.LockoutThreshold; i++ {
+		if _, err := CheckLoginAllowed("alice", "10.0.0.1"); err != nil {
+			t.Fatalf("login %d: expected to be allowed, got %v", i+1, err)
+		}
+
+		if err := ForgiveLoginAttempt("alice", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/model"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidMagicLink   = errors.New("invalid, expired or already used login link")
+	ErrMagicLinkThrottled = errors.New("login link was sent recently")
+)
+
+// CreateMagicLinkToken signs a login token for the user's current email and
+// returns ErrMagicLinkThrottled if the previous one is too recent.
+func CreateMagicLinkToken(user *model.User, scopes []string) (string, time.Time, error) {
+	now := time.Now()
+
+	if user.MagicLinkSentAt != nil && now.Sub(*user.MagicLinkSentAt) < config.MagicLinkResendAfter {
+		return "", time.Time{}, ErrMagicLinkThrottled
+	}
+
+	jti, err := newTokenId()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := now.Add(config.MagicLinkDuration)
+	claims := config.MagicLinkClaims{
+		Email: user.Email,
+		Scope: FormatScope(scopes),
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Subject:   strconv.Itoa(int(user.Id)),
+			Issuer:    config.RefreshTokenIssuer,
+			Audience:  config.MagicLinkAudience,
+			ExpiresAt: expiresAt.Unix(),
+		},
+	}
+
+	token, err := SignToken(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	if err := database.DB.Model(user).Update("magic_link_sent_at", now).Error; err != nil {
+		return "", time.Time{}, err
+	}
+
+	user.MagicLinkSentAt = &now
+
+	return token, expiresAt, nil
+}
+
+// ConsumeMagicLink returns the user and the scopes of a login link. The jti
+// goes on the revocation list, the insert only succeeds once, so a link can
+// not be used twice. Opening the link proves the email, so it is verified
+// too.
+func ConsumeMagicLink(magicLink string) (*model.User, []string, error) {
+	token, err := ParseToken(magicLink, &config.MagicLinkClaims{})
+	if err != nil {
+		return nil, nil, ErrInvalidMagicLink
+	}
+
+	claims, ok := token.Claims.(*config.MagicLinkClaims)
+	if !ok || !token.Valid || claims.Audience != config.MagicLinkAudience || claims.Id == "" {
+		return nil, nil, ErrInvalidMagicLink
+	}
+
+	userId, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, nil, ErrInvalidMagicLink
+	}
+
+	user, err := LoadUser(uint(userId))
+	if err != nil {
+		return nil, nil, ErrInvalidMagicLink
+	}
+
+	if user.Email != claims.Email || user.AuthSource == config.AuthSourceLdap {
+		return nil, nil, ErrInvalidMagicLink
+	}
+
+	result := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.RevokedToken{
+		Jti:       claims.Id,
+		UserId:    user.Id,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	})
+
+	if result.Error != nil {
+		return nil, nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, nil, ErrInvalidMagicLink
+	}
+
+	if err := CheckUserStatus(user); err != nil {
+		return nil, nil, err
+	}
+
+	if !user.EmailVerified {
+		if err := database.DB.Model(user).Update("email_verified", true).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return user, strings.Fields(claims.Scope), nil
+}
@@ -0,0 +1,339 @@
+package auth
+
+import (
+	"crypto/rand"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrTotpAlreadyEnabled  = errors.New("totp is already enabled")
+	ErrTotpNotEnrolled     = errors.New("totp enrollment not started")
+	ErrInvalidMfaCode      = errors.New("invalid mfa code")
+	ErrInvalidMfaChallenge = errors.New("invalid or expired mfa challenge")
+)
+
+func getTotpCredential(tx *gorm.DB, userId uint) (*model.TotpCredential, error) {
+	var credential model.TotpCredential
+
+	err := tx.Where("user_id = ?", userId).First(&credential).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &credential, nil
+}
+
+func IsTotpEnabled(userId uint) (bool, error) {
+	credential, err := getTotpCredential(database.DB, userId)
+	if err != nil {
+		return false, err
+	}
+
+	return credential != nil && credential.ConfirmedAt != nil, nil
+}
+
+// EnrollTotp starts, or restarts, enrollment with a fresh secret. The secret
+// only becomes active once ConfirmTotp sees a valid code for it.
+func EnrollTotp(user *model.User) (secret, uri string, err error) {
+	credential, err := getTotpCredential(database.DB, user.Id)
+	if err != nil {
+		return "", "", err
+	}
+
+	if credential != nil && credential.ConfirmedAt != nil {
+		return "", "", ErrTotpAlreadyEnabled
+	}
+
+	secret, err = newTotpSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	if credential == nil {
+		err = database.DB.Create(&model.TotpCredential{UserId: user.Id, Secret: secret}).Error
+	} else {
+		err = database.DB.Model(credential).Update("secret", secret).Error
+	}
+
+	if err != nil {
+		return "", "", err
+	}
+
+	return secret, TotpURI(secret, user.Username), nil
+}
+
+// ConfirmTotp enables totp and returns the recovery codes, which are only
+// ever shown this once.
+func ConfirmTotp(user *model.User, code string) ([]string, error) {
+	credential, err := getTotpCredential(database.DB, user.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	if credential == nil {
+		return nil, ErrTotpNotEnrolled
+	}
+
+	if credential.ConfirmedAt != nil {
+		return nil, ErrTotpAlreadyEnabled
+	}
+
+	step, err := matchTotp(credential.Secret, code, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if step < 0 {
+		return nil, ErrInvalidMfaCode
+	}
+
+	var codes []string
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(credential).Updates(map[string]interface{}{
+			"confirmed_at":   now,
+			"last_used_step": step,
+		}).Error; err != nil {
+			return err
+		}
+
+		codes, err = replaceRecoveryCodes(tx, user.Id)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func DisableTotp(user *model.User, code string) error {
+	if err := VerifyMfaCode(user.Id, code); err != nil {
+		return err
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.Id).Delete(&model.TotpCredential{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Where("user_id = ?", user.Id).Delete(&model.RecoveryCode{}).Error
+	})
+}
+
+// RegenerateRecoveryCodes invalidates the remaining recovery codes and
+// returns a new set, it needs a valid code like disabling does.
+func RegenerateRecoveryCodes(user *model.User, code string) ([]string, error) {
+	if err := VerifyMfaCode(user.Id, code); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.Id)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userId uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userId).Delete(&model.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, config.RecoveryCodeCount)
+	rows := make([]model.RecoveryCode, 0, config.RecoveryCodeCount)
+
+	for i := 0; i < config.RecoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(totpEncoding.EncodeToString(b))
+		codes = append(codes, code[:4]+"-"+code[4:])
+		rows = append(rows, model.RecoveryCode{UserId: userId, CodeHash: HashToken(code)})
+	}
+
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// VerifyMfaCode accepts either a current totp code or an unused recovery
+// code. Each totp code and recovery code can only be used once.
+func VerifyMfaCode(userId uint, code string) error {
+	code = strings.TrimSpace(code)
+
+	credential, err := getTotpCredential(database.DB, userId)
+	if err != nil {
+		return err
+	}
+
+	if credential == nil || credential.ConfirmedAt == nil {
+		return ErrInvalidMfaCode
+	}
+
+	if len(code) == config.TotpDigits {
+		step, err := matchTotp(credential.Secret, code, time.Now())
+		if err != nil {
+			return err
+		}
+
+		if step < 0 {
+			return ErrInvalidMfaCode
+		}
+
+		result := database.DB.Model(&model.TotpCredential{}).
+			Where("id = ? AND last_used_step < ?", credential.Id, step).
+			Update("last_used_step", step)
+
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrInvalidMfaCode
+		}
+
+		return nil
+	}
+
+	normalized := strings.ToLower(strings.ReplaceAll(code, "-", ""))
+
+	result := database.DB.Model(&model.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userId, HashToken(normalized)).
+		Update("used_at", time.Now())
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrInvalidMfaCode
+	}
+
+	return nil
+}
+
+// GenerateMfaChallenge is handed out instead of tokens when the password was
+// correct but a second factor is still needed.
+func GenerateMfaChallenge(user *model.User, scopes []string) (string, error) {
+	jti, err := newTokenId()
+	if err != nil {
+		return "", err
+	}
+
+	claims := config.MfaChallengeClaims{
+		Scope: FormatScope(scopes),
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Subject:   strconv.Itoa(int(user.Id)),
+			Issuer:    config.RefreshTokenIssuer,
+			Audience:  config.MfaChallengeAudience,
+			ExpiresAt: time.Now().Add(config.MfaChallengeDuration).Unix(),
+		},
+	}
+
+	return SignToken(claims)
+}
+
+// ParseMfaChallenge returns the user and the scopes the login asked for. A
+// challenge that already finished a login is refused.
+func ParseMfaChallenge(challenge string) (uint, []string, error) {
+	claims, err := parseMfaChallengeClaims(challenge)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	revoked, err := IsAccessTokenRevoked(claims.Id)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if revoked {
+		return 0, nil, ErrInvalidMfaChallenge
+	}
+
+	userId, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return 0, nil, ErrInvalidMfaChallenge
+	}
+
+	scopes, err := ParseScope(claims.Scope)
+	if err != nil {
+		return 0, nil, ErrInvalidMfaChallenge
+	}
+
+	return uint(userId), scopes, nil
+}
+
+// ConsumeMfaChallenge puts the jti of a challenge on the revocation list once
+// its code was accepted, the insert only succeeds once so a challenge
+// finishes one login only.
+func ConsumeMfaChallenge(challenge string) error {
+	claims, err := parseMfaChallengeClaims(challenge)
+	if err != nil {
+		return err
+	}
+
+	userId, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return ErrInvalidMfaChallenge
+	}
+
+	result := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.RevokedToken{
+		Jti:       claims.Id,
+		UserId:    uint(userId),
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	})
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrInvalidMfaChallenge
+	}
+
+	return nil
+}
+
+func parseMfaChallengeClaims(challenge string) (*config.MfaChallengeClaims, error) {
+	token, err := ParseToken(challenge, &config.MfaChallengeClaims{})
+	if err != nil {
+		return nil, ErrInvalidMfaChallenge
+	}
+
+	claims, ok := token.Claims.(*config.MfaChallengeClaims)
+	if !ok || !token.Valid || claims.Audience != config.MfaChallengeAudience || claims.Id == "" {
+		return nil, ErrInvalidMfaChallenge
+	}
+
+	return claims, nil
+}
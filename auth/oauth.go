@@ -0,0 +1,310 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/model"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUnauthorizedClient      = errors.New("the client is not allowed to use this grant type")
+	ErrInvalidGrant            = errors.New("invalid, expired or already used grant")
+	ErrPkceRequired            = errors.New("code_challenge with code_challenge_method S256 is required")
+	ErrUnsupportedResponseType = errors.New("only the code response type is supported")
+	ErrInvalidAuthorizeRequest = errors.New("invalid or expired authorization request")
+)
+
+// AuthorizationRequest is what a client sends to /oauth/authorize.
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientId            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// Nonce is echoed in the id token so the client can tie it to its request
+	Nonce string
+}
+
+// ValidateAuthorizationRequest returns the client and the granted scopes. It
+// fills in the redirect uri when the client only has one. The client is only
+// returned once the redirect uri checked out, so an error without a client,
+// like ErrInvalidClient, ErrInvalidRedirectURI or a database error, must not
+// be sent to the redirect uri. Any other error is reported to the client
+// there.
+func ValidateAuthorizationRequest(req *AuthorizationRequest) (*model.OauthClient, []string, error) {
+	client, err := GetOAuthClient(req.ClientId)
+	if errors.Is(err, ErrOAuthClientNotFound) {
+		return nil, nil, ErrInvalidClient
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	redirectURIs := client.RedirectURIList()
+	if req.RedirectURI == "" && len(redirectURIs) == 1 {
+		req.RedirectURI = redirectURIs[0]
+	}
+
+	// exact match only, prefix matching has been the source of too many open
+	// redirects
+	allowed := false
+	for _, uri := range redirectURIs {
+		if uri == req.RedirectURI {
+			allowed = true
+		}
+	}
+
+	if !allowed {
+		return nil, nil, ErrInvalidRedirectURI
+	}
+
+	if req.ResponseType != "code" {
+		return client, nil, ErrUnsupportedResponseType
+	}
+
+	if !client.AllowsGrant(config.GrantAuthorizationCode) {
+		return client, nil, ErrUnauthorizedClient
+	}
+
+	// pkce is required for every client, not only public ones
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != config.PkceMethodS256 {
+		return client, nil, ErrPkceRequired
+	}
+
+	scopes, err := clientScopes(client, req.Scope)
+	if err != nil {
+		return client, nil, err
+	}
+
+	return client, scopes, nil
+}
+
+// clientScopes parses a requested scope, which has to stay within what the
+// client was registered for. An empty scope means all of the client's.
+func clientScopes(client *model.OauthClient, scope string) ([]string, error) {
+	allowed := client.ScopeList()
+	if strings.TrimSpace(scope) == "" {
+		return allowed, nil
+	}
+
+	scopes, err := ParseScope(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range scopes {
+		found := false
+		for _, a := range allowed {
+			if s == a {
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, ErrInvalidScope
+		}
+	}
+
+	return scopes, nil
+}
+
+// SignAuthorizationRequest puts a validated request into the consent form.
+func SignAuthorizationRequest(req *AuthorizationRequest, scopes []string) (string, error) {
+	claims := config.OAuthAuthorizeClaims{
+		ClientId:      req.ClientId,
+		RedirectURI:   req.RedirectURI,
+		Scope:         FormatScope(scopes),
+		State:         req.State,
+		CodeChallenge: req.CodeChallenge,
+		Nonce:         req.Nonce,
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    config.RefreshTokenIssuer,
+			Audience:  config.OAuthAuthorizeAudience,
+			ExpiresAt: time.Now().Add(config.OAuthAuthorizeRequestDuration).Unix(),
+		},
+	}
+
+	return SignToken(claims)
+}
+
+func ParseAuthorizationRequest(request string) (*config.OAuthAuthorizeClaims, error) {
+	token, err := ParseToken(request, &config.OAuthAuthorizeClaims{})
+	if err != nil {
+		return nil, ErrInvalidAuthorizeRequest
+	}
+
+	claims, ok := token.Claims.(*config.OAuthAuthorizeClaims)
+	if !ok || !token.Valid || claims.Audience != config.OAuthAuthorizeAudience {
+		return nil, ErrInvalidAuthorizeRequest
+	}
+
+	return claims, nil
+}
+
+// CreateAuthorizationCode is called once the user approved the request.
+func CreateAuthorizationCode(user *model.User, req *config.OAuthAuthorizeClaims) (string, error) {
+	code, err := newTokenId()
+	if err != nil {
+		return "", err
+	}
+
+	// expired codes are cleaned up whenever a new one is made
+	if err := database.DB.Where("expires_at < ?", time.Now()).Delete(&model.OauthAuthorizationCode{}).Error; err != nil {
+		return "", err
+	}
+
+	err = database.DB.Create(&model.OauthAuthorizationCode{
+		CodeHash:      HashToken(code),
+		ClientId:      req.ClientId,
+		UserId:        user.Id,
+		RedirectURI:   req.RedirectURI,
+		Scope:         req.Scope,
+		CodeChallenge: req.CodeChallenge,
+		Nonce:         req.Nonce,
+		AuthTime:      time.Now(),
+		ExpiresAt:     time.Now().Add(config.OAuthCodeDuration),
+	}).Error
+
+	if err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// ExchangeAuthorizationCode uses up a code and returns the user and the code,
+// which has the granted scope and the nonce, after checking it belongs to
+// client and redirectURI and that codeVerifier matches the pkce challenge.
+func ExchangeAuthorizationCode(client *model.OauthClient, code, redirectURI, codeVerifier string) (*model.User, *model.OauthAuthorizationCode, error) {
+	if !client.AllowsGrant(config.GrantAuthorizationCode) {
+		return nil, nil, ErrUnauthorizedClient
+	}
+
+	var authCode model.OauthAuthorizationCode
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("code_hash = ? AND expires_at > ?", HashToken(code), time.Now()).First(&authCode).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidGrant
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// deleted whatever the outcome, a code gets one attempt
+		result := tx.Where("code_hash = ?", authCode.CodeHash).Delete(&model.OauthAuthorizationCode{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrInvalidGrant
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if authCode.ClientId != client.Id || authCode.RedirectURI != redirectURI || !verifyPkce(authCode.CodeChallenge, codeVerifier) {
+		return nil, nil, ErrInvalidGrant
+	}
+
+	user, err := LoadUser(authCode.UserId)
+	if err != nil {
+		return nil, nil, ErrInvalidGrant
+	}
+
+	if err := CheckUserStatus(user); err != nil {
+		return nil, nil, ErrInvalidGrant
+	}
+
+	return user, &authCode, nil
+}
+
+// verifyPkce checks an S256 code_verifier, rfc 7636 section 4.6.
+func verifyPkce(challenge, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:]) == challenge
+}
+
+// IssueClientCredentialsToken returns an access token for the client itself,
+// it has no user and no refresh token.
+func IssueClientCredentialsToken(client *model.OauthClient, scope string) (string, []string, error) {
+	if !client.IsConfidential() || !client.AllowsGrant(config.GrantClientCredentials) {
+		return "", nil, ErrUnauthorizedClient
+	}
+
+	scopes, err := clientScopes(client, scope)
+	if err != nil {
+		return "", nil, err
+	}
+
+	jti, err := newTokenId()
+	if err != nil {
+		return "", nil, err
+	}
+
+	claims := config.Claims{
+		Scope:    FormatScope(scopes),
+		ClientId: client.Id,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Subject:   client.Id,
+			ExpiresAt: time.Now().Add(config.AccessTokenDuration).Unix(),
+			Issuer:    config.RefreshTokenIssuer,
+			Audience:  config.AccessTokenAudience,
+		},
+	}
+
+	token, err := SignToken(claims)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, scopes, nil
+}
+
+// RevokeOAuthToken implements rfc 7009: a refresh token ends its session, an
+// access token is put on the revocation list. Tokens that are invalid or
+// belong to another client are ignored, the caller answers 200 either way.
+func RevokeOAuthToken(client *model.OauthClient, token string) error {
+	parsed, err := ParseToken(token, &config.Claims{})
+	if err != nil {
+		return nil
+	}
+
+	claims, ok := parsed.Claims.(*config.Claims)
+	if !ok || !parsed.Valid || claims.ClientId != client.Id {
+		return nil
+	}
+
+	switch claims.Audience {
+	case config.RefreshTokenAudience:
+		if err := DeleteSession(claims.Id, claims.SessionId); err != nil && !errors.Is(err, ErrSessionNotFound) {
+			return err
+		}
+	case config.AccessTokenAudience:
+		return RevokeAccessToken(claims.StandardClaims.Id, claims.Id, claims.ExpiresAt)
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/model"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrOAuthClientNotFound = errors.New("oauth client not found")
+	ErrInvalidClient       = errors.New("client authentication failed")
+	ErrInvalidRedirectURI  = errors.New("redirect uris must be absolute urls without a fragment")
+	ErrRedirectURIRequired = errors.New("the authorization_code grant needs at least one redirect uri")
+	ErrPublicClientGrant   = errors.New("the client_credentials grant needs a confidential client")
+	ErrUnknownGrantType    = errors.New("unknown grant type")
+	ErrClientScope         = errors.New("the account and admin scopes are not available to oauth clients")
+)
+
+// CreateOAuthClient registers a client, confidential clients get a secret
+// which, like personal access tokens, is only returned this once.
+func CreateOAuthClient(name string, redirectURIs, grantTypes, scopes []string, confidential bool) (*model.OauthClient, string, error) {
+	for _, grantType := range grantTypes {
+		switch grantType {
+		case config.GrantAuthorizationCode, config.GrantRefreshToken, config.GrantClientCredentials, config.GrantDeviceCode:
+		default:
+			return nil, "", ErrUnknownGrantType
+		}
+	}
+
+	client := &model.OauthClient{
+		Name:         name,
+		RedirectURIs: strings.Join(redirectURIs, " "),
+		GrantTypes:   strings.Join(grantTypes, " "),
+		Scopes:       FormatScope(scopes),
+	}
+
+	if client.AllowsGrant(config.GrantAuthorizationCode) && len(redirectURIs) == 0 {
+		return nil, "", ErrRedirectURIRequired
+	}
+
+	if client.AllowsGrant(config.GrantClientCredentials) && !confidential {
+		return nil, "", ErrPublicClientGrant
+	}
+
+	for _, uri := range redirectURIs {
+		parsed, err := url.Parse(uri)
+		if err != nil || !parsed.IsAbs() || parsed.Fragment != "" || strings.Contains(uri, " ") {
+			return nil, "", ErrInvalidRedirectURI
+		}
+	}
+
+	if err := ValidateScopes(scopes); err != nil {
+		return nil, "", err
+	}
+
+	for _, scope := range scopes {
+		if !HasScope(config.OAuthClientScopes, scope) {
+			return nil, "", ErrClientScope
+		}
+	}
+
+	id, err := newTokenId()
+	if err != nil {
+		return nil, "", err
+	}
+
+	client.Id = id
+
+	var secret string
+	if confidential {
+		secret, err = newTokenId()
+		if err != nil {
+			return nil, "", err
+		}
+
+		client.SecretHash = HashToken(secret)
+	}
+
+	if err := database.DB.Create(client).Error; err != nil {
+		return nil, "", err
+	}
+
+	return client, secret, nil
+}
+
+// EnsureOAuthTestClient creates or updates the public client used to try the
+// authorization code flow locally.
+func EnsureOAuthTestClient(redirectURI string) error {
+	client := model.OauthClient{
+		Id:           config.OAuthTestClientId,
+		Name:         "Test client",
+		RedirectURIs: redirectURI,
+		GrantTypes:   config.GrantAuthorizationCode + " " + config.GrantRefreshToken,
+		Scopes:       FormatScope(config.OAuthClientScopes),
+	}
+
+	return database.DB.Where(model.OauthClient{Id: client.Id}).Assign(client).FirstOrCreate(&client).Error
+}
+
+func ListOAuthClients() ([]model.OauthClient, error) {
+	var clients []model.OauthClient
+
+	if err := database.DB.Order("created_at").Find(&clients).Error; err != nil {
+		return nil, err
+	}
+
+	return clients, nil
+}
+
+func GetOAuthClient(id string) (*model.OauthClient, error) {
+	var client model.OauthClient
+
+	err := database.DB.Where("id = ?", id).First(&client).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrOAuthClientNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &client, nil
+}
+
+// DeleteOAuthClient also ends every session the client started, so its
+// tokens stop working right away.
+func DeleteOAuthClient(id string) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ?", id).Delete(&model.OauthClient{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrOAuthClientNotFound
+		}
+
+		if err := tx.Where("client_id = ?", id).Delete(&model.OauthAuthorizationCode{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("client_id = ?", id).Delete(&model.OauthDeviceCode{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Where("client_id = ?", id).Delete(&model.RefreshToken{}).Error
+	})
+}
+
+// AuthenticateOAuthClient checks the client secret of confidential clients,
+// public clients must not send one.
+func AuthenticateOAuthClient(id, secret string) (*model.OauthClient, error) {
+	client, err := GetOAuthClient(id)
+	if errors.Is(err, ErrOAuthClientNotFound) {
+		return nil, ErrInvalidClient
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !client.IsConfidential() {
+		if secret != "" {
+			return nil, ErrInvalidClient
+		}
+
+		return client, nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(client.SecretHash), []byte(HashToken(secret))) != 1 {
+		return nil, ErrInvalidClient
+	}
+
+	return client, nil
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/model"
+)
+
+const (
+	testRedirectURI  = "https://app.example.com/callback"
+	testCodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+// authorizeTestClient registers a public client and returns a code alice
+// approved for it, bound to testCodeVerifier.
+func authorizeTestClient(t *testing.T) (*model.OauthClient, string) {
+	t.Helper()
+
+	setupAuth(t)
+
+	client, _, err := CreateOAuthClient("app", []string{testRedirectURI}, []string{config.GrantAuthorizationCode}, []string{config.ScopeProfile}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256([]byte(testCodeVerifier))
+
+	code, err := CreateAuthorizationCode(createTestUser(t, "alice"), &config.OAuthAuthorizeClaims{
+		ClientId:      client.Id,
+		RedirectURI:   testRedirectURI,
+		Scope:         config.ScopeProfile,
+		CodeChallenge: base64.RawURLEncoding.EncodeToString(sum[:]),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client, code
+}
+
+func TestExchangeAuthorizationCodeIsSingleUse(t *testing.T) {
+	client, code := authorizeTestClient(t)
+
+	user, authCode, err := ExchangeAuthorizationCode(client, code, testRedirectURI, testCodeVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.Username != "alice" || authCode.Scope != config.ScopeProfile {
+		t.Fatalf("expected alice with the approved scope, got %s with %q", user.Username, authCode.Scope)
+	}
+
+	if _, _, err := ExchangeAuthorizationCode(client, code, testRedirectURI, testCodeVerifier); !errors.Is(err, ErrInvalidGrant) {
+		t.Fatalf("expected the used code to be refused, got %v", err)
+	}
+}
+
+func TestExchangeAuthorizationCodeChecksThePkceVerifier(t *testing.T) {
+	tests := []struct {
+		name     string
+		verifier string
+	}{
+		{"other verifier", strings.Repeat("a", 43)},
+		{"no verifier", ""},
+		{"the challenge itself", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, code := authorizeTestClient(t)
+
+			if _, _, err := ExchangeAuthorizationCode(client, code, testRedirectURI, test.verifier); !errors.Is(err, ErrInvalidGrant) {
+				t.Fatalf("expected ErrInvalidGrant, got %v", err)
+			}
+
+			// a wrong verifier uses the code up, it can not be tried again
+			if _, _, err := ExchangeAuthorizationCode(client, code, testRedirectURI, testCodeVerifier); !errors.Is(err, ErrInvalidGrant) {
+				t.Fatalf("expected the code to be gone after a failed exchange, got %v", err)
+			}
+		})
+	}
+}
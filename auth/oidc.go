@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/model"
+)
+
+// GenerateIdToken issues an openid connect id token for clientId, nonce and
+// authTime are left out when they are empty.
+func GenerateIdToken(issuer string, user *model.User, clientId string, scopes []string, nonce string, authTime time.Time) (string, error) {
+	now := time.Now()
+
+	claims := config.IdTokenClaims{
+		Nonce: nonce,
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    issuer,
+			Subject:   strconv.Itoa(int(user.Id)),
+			Audience:  clientId,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(config.AccessTokenDuration).Unix(),
+		},
+	}
+
+	if !authTime.IsZero() {
+		claims.AuthTime = authTime.Unix()
+	}
+
+	if HasScope(scopes, config.ScopeEmail) {
+		verified := user.EmailVerified
+		claims.Email = user.Email
+		claims.EmailVerified = &verified
+	}
+
+	if HasScope(scopes, config.ScopeProfile) {
+		claims.PreferredUsername = user.Username
+	}
+
+	return SignToken(claims)
+}
+
+// UserInfo is the /userinfo response, limited to the granted scopes like the
+// id token.
+func UserInfo(user *model.User, scopes []string) map[string]interface{} {
+	info := map[string]interface{}{
+		"sub": strconv.Itoa(int(user.Id)),
+	}
+
+	if HasScope(scopes, config.ScopeEmail) {
+		info["email"] = user.Email
+		info["email_verified"] = user.EmailVerified
+	}
+
+	if HasScope(scopes, config.ScopeProfile) {
+		info["preferred_username"] = user.Username
+	}
+
+	return info
+}
@@ -0,0 +1,296 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/helper/hasher"
+	"github.com/yosikez/crudAuth/model"
+	"gorm.io/gorm"
+)
+
+// PasswordPolicyError lists every rule a password broke.
+type PasswordPolicyError struct {
+	Violations []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return strings.Join(e.Violations, ", ")
+}
+
+// PasswordRule checks one aspect of a new password for user. It returns a
+// message describing the violation, or "" when the password passes. user is
+// not saved yet on registration, so Id is 0 then.
+type PasswordRule interface {
+	Check(tx *gorm.DB, password string, user *model.User) (string, error)
+}
+
+var (
+	passwordRules       []PasswordRule
+	passwordHistorySize int
+)
+
+// InitPasswordPolicy builds the rules from cfg, more can be added with
+// AddPasswordRule.
+func InitPasswordPolicy(cfg *config.PasswordPolicy) error {
+	rules := []PasswordRule{
+		lengthRule{min: cfg.MinLength, max: cfg.MaxLength},
+		characterClassRule{upper: cfg.RequireUpper, lower: cfg.RequireLower, digit: cfg.RequireDigit, symbol: cfg.RequireSymbol},
+		personalInfoRule{},
+	}
+
+	// hasher.Configure has run by now
+	if max := hasher.MaxPasswordBytes(); max > 0 {
+		rules = append(rules, byteLengthRule{max: max})
+	}
+
+	if cfg.HistorySize > 0 {
+		rules = append(rules, historyRule{size: cfg.HistorySize})
+	}
+
+	if cfg.BreachedCorpusDir != "" {
+		info, err := os.Stat(cfg.BreachedCorpusDir)
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", cfg.BreachedCorpusDir)
+		}
+
+		rules = append(rules, breachedRule{dir: cfg.BreachedCorpusDir})
+	}
+
+	passwordRules = rules
+	passwordHistorySize = cfg.HistorySize
+
+	return nil
+}
+
+func AddPasswordRule(rule PasswordRule) {
+	passwordRules = append(passwordRules, rule)
+}
+
+// CheckPasswordPolicy returns a *PasswordPolicyError when password breaks
+// any of the rules.
+func CheckPasswordPolicy(password string, user *model.User) error {
+	return checkPasswordPolicy(database.DB, password, user)
+}
+
+func checkPasswordPolicy(tx *gorm.DB, password string, user *model.User) error {
+	var violations []string
+
+	for _, rule := range passwordRules {
+		violation, err := rule.Check(tx, password, user)
+		if err != nil {
+			return err
+		}
+
+		if violation != "" {
+			violations = append(violations, violation)
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+
+	return nil
+}
+
+// recordPassword stores the user's new password hash in the history and
+// drops entries the policy no longer looks at.
+func recordPassword(tx *gorm.DB, userId uint, hash string) error {
+	if passwordHistorySize == 0 {
+		return nil
+	}
+
+	if err := tx.Create(&model.PasswordHistory{UserId: userId, PasswordHash: hash}).Error; err != nil {
+		return err
+	}
+
+	keep := tx.Model(&model.PasswordHistory{}).Select("id").Where("user_id = ?", userId).Order("id DESC").Limit(passwordHistorySize)
+
+	return tx.Where("user_id = ? AND id NOT IN (?)", userId, keep).Delete(&model.PasswordHistory{}).Error
+}
+
+// RecordPassword is recordPassword for a user whose password was just set
+// outside of this package, like on registration.
+func RecordPassword(user *model.User) error {
+	return recordPassword(database.DB, user.Id, user.Password)
+}
+
+type lengthRule struct {
+	min, max int
+}
+
+func (r lengthRule) Check(tx *gorm.DB, password string, user *model.User) (string, error) {
+	length := utf8.RuneCountInString(password)
+
+	if length < r.min {
+		return fmt.Sprintf("password must be at least %d characters long", r.min), nil
+	}
+
+	if length > r.max {
+		return fmt.Sprintf("password must be at most %d characters long", r.max), nil
+	}
+
+	return "", nil
+}
+
+// byteLengthRule keeps passwords within what the hasher takes, the length
+// rule counts characters, which can be several bytes each.
+type byteLengthRule struct {
+	max int
+}
+
+func (r byteLengthRule) Check(tx *gorm.DB, password string, user *model.User) (string, error) {
+	if len(password) > r.max {
+		return fmt.Sprintf("password must be at most %d bytes long", r.max), nil
+	}
+
+	return "", nil
+}
+
+type characterClassRule struct {
+	upper, lower, digit, symbol bool
+}
+
+func (r characterClassRule) Check(tx *gorm.DB, password string, user *model.User) (string, error) {
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			hasUpper = true
+		case unicode.IsLower(c):
+			hasLower = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		default:
+			hasSymbol = true
+		}
+	}
+
+	var missing []string
+
+	if r.upper && !hasUpper {
+		missing = append(missing, "an uppercase letter")
+	}
+
+	if r.lower && !hasLower {
+		missing = append(missing, "a lowercase letter")
+	}
+
+	if r.digit && !hasDigit {
+		missing = append(missing, "a digit")
+	}
+
+	if r.symbol && !hasSymbol {
+		missing = append(missing, "a symbol")
+	}
+
+	if len(missing) > 0 {
+		return "password must contain " + strings.Join(missing, ", "), nil
+	}
+
+	return "", nil
+}
+
+// personalInfoRule refuses passwords containing the username or the local
+// part of the email, shorter values are too likely to match by accident.
+type personalInfoRule struct{}
+
+const minPersonalInfoLength = 3
+
+func (personalInfoRule) Check(tx *gorm.DB, password string, user *model.User) (string, error) {
+	lowered := strings.ToLower(password)
+	localPart, _, _ := strings.Cut(user.Email, "@")
+
+	for _, value := range []string{user.Username, localPart} {
+		value = strings.ToLower(value)
+		if utf8.RuneCountInString(value) >= minPersonalInfoLength && strings.Contains(lowered, value) {
+			return "password must not contain your username or email", nil
+		}
+	}
+
+	return "", nil
+}
+
+// historyRule refuses the current password and the ones kept in the
+// password history.
+type historyRule struct {
+	size int
+}
+
+func (r historyRule) Check(tx *gorm.DB, password string, user *model.User) (string, error) {
+	if user.Id == 0 {
+		return "", nil
+	}
+
+	var hashes []string
+	if err := tx.Model(&model.PasswordHistory{}).Where("user_id = ?", user.Id).Order("id DESC").Limit(r.size).Pluck("password_hash", &hashes).Error; err != nil {
+		return "", err
+	}
+
+	if user.Password != "" {
+		hashes = append(hashes, user.Password)
+	}
+
+	for _, hash := range hashes {
+		if ok, _ := hasher.Verify(hash, password); ok {
+			return fmt.Sprintf("password must not be one of your last %d passwords", r.size), nil
+		}
+	}
+
+	return "", nil
+}
+
+// breachedRule looks the password up in a local copy of a breached password
+// corpus. Only the file for the first 5 characters of the sha1 is read, the
+// same k-anonymity split the pwned passwords range api uses, so the corpus
+// can be kept up to date with that api's responses.
+type breachedRule struct {
+	dir string
+}
+
+func (r breachedRule) Check(tx *gorm.DB, password string, user *model.User) (string, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(r.dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		candidate, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(candidate, suffix) && count != "0" {
+			return "password has appeared in a data breach, choose a different one", nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", nil
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/model"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidResetToken      = errors.New("invalid or expired password reset token")
+	ErrPasswordResetThrottled = errors.New("password reset email was sent recently")
+)
+
+// CreatePasswordResetToken returns a new single-use reset token, only its
+// hash is stored. Older unused tokens of the user stop working, so it returns
+// ErrPasswordResetThrottled if the previous one is too recent, otherwise
+// anyone could keep replacing the link in the user's inbox.
+func CreatePasswordResetToken(user *model.User) (token string, expiresAt time.Time, err error) {
+	now := time.Now()
+
+	if user.PasswordResetSentAt != nil && now.Sub(*user.PasswordResetSentAt) < config.PasswordResetResendAfter {
+		return "", time.Time{}, ErrPasswordResetThrottled
+	}
+
+	token, err = newTokenId()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt = now.Add(config.PasswordResetTokenDuration)
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", user.Id).
+			Update("used_at", now).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(user).Update("password_reset_sent_at", now).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&model.PasswordResetToken{
+			UserId:    user.Id,
+			TokenHash: HashToken(token),
+			ExpiresAt: expiresAt,
+		}).Error
+	})
+
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	user.PasswordResetSentAt = &now
+
+	return token, expiresAt, nil
+}
+
+// ResetPassword sets a new password for the owner of the token, uses up the
+// token and ends every session of the user. A password the policy refuses is
+// returned as a *PasswordPolicyError.
+func ResetPassword(token, password string) (*model.User, error) {
+	var user model.User
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		var resetToken model.PasswordResetToken
+		err := tx.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", HashToken(token), now).First(&resetToken).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidResetToken
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := tx.First(&user, resetToken.UserId).Error; err != nil {
+			return err
+		}
+
+		// checked before the token is used up, so a rejected password can be
+		// retried with the same link
+		if err := checkPasswordPolicy(tx, password, &user); err != nil {
+			return err
+		}
+
+		// the used_at condition makes sure a token racing with itself only wins once
+		result := tx.Model(&resetToken).Where("used_at IS NULL").Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrInvalidResetToken
+		}
+
+		if err := setPassword(tx, &user, password); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&user).Update("password_reset_required", false).Error; err != nil {
+			return err
+		}
+
+		if err := recordPassword(tx, user.Id, user.Password); err != nil {
+			return err
+		}
+
+		return tx.Where("user_id = ?", user.Id).Delete(&model.RefreshToken{}).Error
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
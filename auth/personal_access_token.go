@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/model"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrPersonalAccessTokenNotFound = errors.New("personal access token not found")
+	ErrInvalidPersonalAccessToken  = errors.New("invalid or expired personal access token")
+	ErrScopeNotAllowed             = errors.New("scope is not allowed for personal access tokens")
+)
+
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, config.PersonalAccessTokenPrefix)
+}
+
+// CreatePersonalAccessToken returns the token itself next to the stored row,
+// only its hash is kept so it can not be shown again.
+func CreatePersonalAccessToken(user *model.User, name string, scopes []string, expiresAt *time.Time) (string, *model.PersonalAccessToken, error) {
+	if err := ValidateScopes(scopes); err != nil {
+		return "", nil, err
+	}
+
+	for _, scope := range scopes {
+		if !HasScope(config.PersonalAccessTokenScopes, scope) {
+			return "", nil, ErrScopeNotAllowed
+		}
+	}
+
+	secret, err := newTokenId()
+	if err != nil {
+		return "", nil, err
+	}
+
+	token := config.PersonalAccessTokenPrefix + secret
+
+	pat := model.PersonalAccessToken{
+		UserId:    user.Id,
+		Name:      name,
+		TokenHash: HashToken(token),
+		Scopes:    strings.Join(scopes, ","),
+		ExpiresAt: expiresAt,
+	}
+
+	if err := database.DB.Create(&pat).Error; err != nil {
+		return "", nil, err
+	}
+
+	return token, &pat, nil
+}
+
+func ListPersonalAccessTokens(userId uint) ([]model.PersonalAccessToken, error) {
+	var tokens []model.PersonalAccessToken
+
+	if err := database.DB.Where("user_id = ?", userId).Order("id").Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func DeletePersonalAccessToken(userId, id uint) error {
+	result := database.DB.Where("user_id = ? AND id = ?", userId, id).Delete(&model.PersonalAccessToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrPersonalAccessTokenNotFound
+	}
+
+	return nil
+}
+
+// AuthenticatePersonalAccessToken finds the token and its user. The user is
+// loaded on every request, so disabling the account or losing a permission
+// applies to the token right away.
+func AuthenticatePersonalAccessToken(token string) (*model.PersonalAccessToken, *model.User, error) {
+	var pat model.PersonalAccessToken
+
+	now := time.Now()
+	err := database.DB.Where("token_hash = ? AND (expires_at IS NULL OR expires_at > ?)", HashToken(token), now).First(&pat).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrInvalidPersonalAccessToken
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := LoadUser(pat.UserId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := CheckUserStatus(user); err != nil {
+		return nil, nil, err
+	}
+
+	if pat.LastUsedAt == nil || now.Sub(*pat.LastUsedAt) > config.PersonalAccessTokenTouchInterval {
+		if err := database.DB.Model(&pat).UpdateColumn("last_used_at", now).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return &pat, user, nil
+}
+
+// PersonalAccessTokenPermissions is what the token may do, the permissions of
+// its user that its scopes cover.
+func PersonalAccessTokenPermissions(pat *model.PersonalAccessToken, user *model.User) []string {
+	covered := map[string]bool{}
+	for _, scope := range pat.ScopeNames() {
+		for _, permission := range config.ScopePermissions[scope] {
+			covered[permission] = true
+		}
+	}
+
+	permissions := []string{}
+	for _, permission := range user.PermissionNames() {
+		if covered[permission] {
+			permissions = append(permissions, permission)
+		}
+	}
+
+	return permissions
+}
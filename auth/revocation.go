@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RevokeAccessToken puts the given jti on the revocation list until the
+// access token would have expired on its own.
+func RevokeAccessToken(jti string, userId uint, expiresAt int64) error {
+	if jti == "" {
+		return errors.New("token has no jti")
+	}
+
+	revoked := model.RevokedToken{
+		Jti:       jti,
+		UserId:    userId,
+		ExpiresAt: time.Unix(expiresAt, 0),
+	}
+
+	return database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&revoked).Error
+}
+
+func IsAccessTokenRevoked(jti string) (bool, error) {
+	var revoked model.RevokedToken
+
+	err := database.DB.Where("jti = ?", jti).First(&revoked).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func PurgeExpiredRevokedTokens() error {
+	return database.DB.Where("expires_at < ?", time.Now()).Delete(&model.RevokedToken{}).Error
+}
+
+// StartRevokedTokenPurger removes expired entries from the revocation list
+// every interval. It blocks, so run it in its own goroutine.
+func StartRevokedTokenPurger(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := PurgeExpiredRevokedTokens(); err != nil {
+			log.Printf("failed to purge revoked tokens : %v", err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrUnknownRole = errors.New("unknown role")
+	ErrLastAdmin   = errors.New("cannot take the admin role from the last admin")
+)
+
+func ListRoles() ([]model.Role, error) {
+	var roles []model.Role
+
+	if err := database.DB.Preload("Permissions").Order("name").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// AssignRoles replaces the roles of a user with the named roles. The admin
+// role can not be taken from the last admin, nobody could give it back.
+func AssignRoles(user *model.User, roleNames []string) error {
+	var roles []model.Role
+
+	if err := database.DB.Where("name IN ?", roleNames).Find(&roles).Error; err != nil {
+		return err
+	}
+
+	if len(roles) != len(roleNames) {
+		return ErrUnknownRole
+	}
+
+	keepsAdmin := false
+	for _, name := range roleNames {
+		if name == config.RoleAdmin {
+			keepsAdmin = true
+		}
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if !keepsAdmin {
+			// the admin role row is locked, so two requests demoting the
+			// last two admins can not both see the other one
+			var adminRole model.Role
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("name = ?", config.RoleAdmin).First(&adminRole).Error
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+
+			if err == nil {
+				var isAdmin, otherAdmins int64
+
+				if err := tx.Table("user_roles").Where("role_id = ? AND user_id = ?", adminRole.Id, user.Id).Count(&isAdmin).Error; err != nil {
+					return err
+				}
+
+				if err := tx.Table("user_roles").Where("role_id = ? AND user_id <> ?", adminRole.Id, user.Id).Count(&otherAdmins).Error; err != nil {
+					return err
+				}
+
+				if isAdmin > 0 && otherAdmins == 0 {
+					return ErrLastAdmin
+				}
+			}
+		}
+
+		return tx.Model(user).Association("Roles").Replace(roles)
+	})
+}
+
+// mapRoles turns the groups a directory or identity provider reports into
+// role names, mapping keys are lowercased. Nil means no mapping is
+// configured, a user none of whose groups are mapped gets the default role.
+func mapRoles(mapping map[string][]string, groups []string) []string {
+	if len(mapping) == 0 {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	roles := []string{}
+
+	for _, group := range groups {
+		for _, role := range mapping[strings.ToLower(group)] {
+			if !seen[role] {
+				seen[role] = true
+				roles = append(roles, role)
+			}
+		}
+	}
+
+	if len(roles) == 0 {
+		roles = append(roles, config.DefaultRole)
+	}
+
+	sort.Strings(roles)
+
+	return roles
+}
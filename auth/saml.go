@@ -0,0 +1,340 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/model"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrSamlNotConfigured    = errors.New("saml single sign-on is not configured")
+	ErrSamlRequest          = errors.New("invalid or expired saml login")
+	ErrInvalidSamlAssertion = errors.New("invalid saml assertion")
+)
+
+var (
+	samlCfg = &config.Saml{}
+	samlSP  *saml.ServiceProvider
+)
+
+// InitSaml sets up the service provider, the identity provider metadata is
+// read once at startup.
+func InitSaml(cfg *config.Saml, appCfg *config.App) error {
+	samlCfg = cfg
+	if !cfg.Enabled() {
+		return nil
+	}
+
+	key, cert, err := loadSamlKeyPair(cfg.CertificateFile, cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	idpMetadata, err := loadIdpMetadata(cfg)
+	if err != nil {
+		return err
+	}
+
+	metadataURL, err := url.Parse(appCfg.URL("/saml/metadata"))
+	if err != nil {
+		return err
+	}
+
+	acsURL, err := url.Parse(appCfg.URL("/saml/acs"))
+	if err != nil {
+		return err
+	}
+
+	entityID := cfg.EntityID
+	if entityID == "" {
+		entityID = metadataURL.String()
+	}
+
+	samlSP = &saml.ServiceProvider{
+		EntityID:          entityID,
+		Key:               key,
+		Certificate:       cert,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		IDPMetadata:       idpMetadata,
+		AuthnNameIDFormat: saml.PersistentNameIDFormat,
+		SignatureMethod:   dsig.RSASHA256SignatureMethod,
+		// every response has to answer a request we sent, so a response can
+		// not be replayed or pushed into another browser
+		AllowIDPInitiated: false,
+	}
+
+	return nil
+}
+
+func loadSamlKeyPair(certFile, keyFile string) (*rsa.PrivateKey, *x509.Certificate, error) {
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, nil, fmt.Errorf("no pem block in %s", keyFile)
+	}
+
+	var key *rsa.PrivateKey
+	if parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		rsaKey, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, nil, fmt.Errorf("%s is not an rsa key", keyFile)
+		}
+
+		key = rsaKey
+	} else if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s : %w", keyFile, err)
+	}
+
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, _ = pem.Decode(certPEM)
+	if block == nil {
+		return nil, nil, fmt.Errorf("no pem block in %s", certFile)
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, cert, nil
+}
+
+func loadIdpMetadata(cfg *config.Saml) (*saml.EntityDescriptor, error) {
+	if cfg.IdpMetadataFile != "" {
+		data, err := os.ReadFile(cfg.IdpMetadataFile)
+		if err != nil {
+			return nil, err
+		}
+
+		return samlsp.ParseMetadata(data)
+	}
+
+	metadataURL, err := url.Parse(cfg.IdpMetadataURL)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.ExternalProviderTimeout)
+	defer cancel()
+
+	return samlsp.FetchMetadata(ctx, http.DefaultClient, *metadataURL)
+}
+
+// SamlMetadata is the service provider metadata to register at the identity
+// provider.
+func SamlMetadata() ([]byte, error) {
+	if samlSP == nil {
+		return nil, ErrSamlNotConfigured
+	}
+
+	return xml.MarshalIndent(samlSP.Metadata(), "", "  ")
+}
+
+// BeginSamlLogin returns the url of the identity provider with a signed
+// AuthnRequest. The request id is kept to check InResponseTo later.
+func BeginSamlLogin(scopes []string) (string, error) {
+	if samlSP == nil {
+		return "", ErrSamlNotConfigured
+	}
+
+	req, err := samlSP.MakeAuthenticationRequest(samlSP.GetSSOBindingLocation(saml.HTTPRedirectBinding), saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return "", err
+	}
+
+	relayState, err := randomURLString()
+	if err != nil {
+		return "", err
+	}
+
+	// expired logins are cleaned up whenever a new one starts
+	if err := database.DB.Where("expires_at < ?", time.Now()).Delete(&model.SamlRequest{}).Error; err != nil {
+		return "", err
+	}
+
+	err = database.DB.Create(&model.SamlRequest{
+		RelayStateHash: HashToken(relayState),
+		RequestId:      req.ID,
+		Scope:          FormatScope(scopes),
+		ExpiresAt:      time.Now().Add(config.SamlRequestDuration),
+	}).Error
+
+	if err != nil {
+		return "", err
+	}
+
+	redirectURL, err := req.Redirect(relayState, samlSP)
+	if err != nil {
+		return "", err
+	}
+
+	return redirectURL.String(), nil
+}
+
+// takeSamlRequest loads and deletes a request, so every response is only
+// accepted once.
+func takeSamlRequest(relayState string) (*model.SamlRequest, error) {
+	var request model.SamlRequest
+
+	err := database.DB.Where("relay_state_hash = ? AND expires_at > ?", HashToken(relayState), time.Now()).First(&request).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSamlRequest
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := database.DB.Where("relay_state_hash = ?", request.RelayStateHash).Delete(&model.SamlRequest{})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, ErrSamlRequest
+	}
+
+	return &request, nil
+}
+
+// CompleteSamlLogin validates the response posted to the assertion consumer
+// service and returns the user and the scopes asked for at the start.
+func CompleteSamlLogin(r *http.Request) (*model.User, []string, error) {
+	if samlSP == nil {
+		return nil, nil, ErrSamlNotConfigured
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return nil, nil, ErrInvalidSamlAssertion
+	}
+
+	request, err := takeSamlRequest(r.PostForm.Get("RelayState"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// checks the signature, issuer, audience, recipient, InResponseTo and
+	// the time window of the assertion
+	assertion, err := samlSP.ParseResponse(r, []string{request.RequestId})
+	if err != nil {
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			log.Printf("rejected saml response : %v", invalid.PrivateErr)
+		}
+
+		return nil, nil, ErrInvalidSamlAssertion
+	}
+
+	// an assertion without an audience restriction would be accepted by
+	// every service provider of the identity provider
+	if len(assertion.Conditions.AudienceRestrictions) == 0 {
+		return nil, nil, ErrInvalidSamlAssertion
+	}
+
+	user, err := samlUser(assertion)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := CheckUserStatus(user); err != nil {
+		return nil, nil, err
+	}
+
+	return user, strings.Fields(request.Scope), nil
+}
+
+// samlAttribute returns the values of the attribute with the given name or
+// friendly name.
+func samlAttribute(assertion *saml.Assertion, name string) []string {
+	var values []string
+
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			if attribute.Name != name && attribute.FriendlyName != name {
+				continue
+			}
+
+			for _, value := range attribute.Values {
+				values = append(values, value.Value)
+			}
+		}
+	}
+
+	return values
+}
+
+// samlUser maps the assertion onto a user. The identity provider is trusted
+// for the email it asserts, so it counts as verified. Roles are synced from
+// the assertion only for accounts the saml login created.
+func samlUser(assertion *saml.Assertion) (*model.User, error) {
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || assertion.Subject.NameID.Value == "" {
+		return nil, ErrInvalidSamlAssertion
+	}
+
+	email := ""
+	if values := samlAttribute(assertion, samlCfg.EmailAttribute); len(values) > 0 {
+		email = values[0]
+	}
+
+	username := ""
+	if values := samlAttribute(assertion, samlCfg.UsernameAttribute); len(values) > 0 {
+		username = values[0]
+	}
+
+	user, err := externalUser(config.SamlProvider, assertion.Subject.NameID.Value, email, true, username, samlCfg.AutoCreateUsers)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := mapRoles(samlCfg.RoleMapping, samlAttribute(assertion, samlCfg.RoleAttribute))
+	if roles == nil {
+		return user, nil
+	}
+
+	// a local account that was linked by its email keeps the roles its
+	// admins gave it, the identity provider only manages the accounts it
+	// created
+	var identity model.ExternalIdentity
+
+	err = database.DB.Where("provider = ? AND subject = ?", config.SamlProvider, assertion.Subject.NameID.Value).First(&identity).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if !identity.CreatedUser {
+		return user, nil
+	}
+
+	if err := AssignRoles(user, roles); err != nil {
+		return nil, err
+	}
+
+	return LoadUser(user.Id)
+}
@@ -0,0 +1,380 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/crewjam/saml"
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/database/databasetest"
+	"github.com/yosikez/crudAuth/model"
+)
+
+const (
+	testAppURL      = "http://localhost:8000"
+	testIdpEntityID = "https://idp.example.com/metadata"
+)
+
+func newSamlKeyPair(t *testing.T, commonName string) (*rsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key, cert
+}
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// fakeIdp signs assertions the way an identity provider does, the service
+// provider trusts the key in its metadata.
+type fakeIdp struct {
+	idp *saml.IdentityProvider
+}
+
+// setupSaml runs InitSaml with a fresh service provider key pair and the
+// metadata of a local identity provider.
+func setupSaml(t *testing.T, roleMapping map[string][]string) *fakeIdp {
+	t.Helper()
+
+	databasetest.Open(t)
+
+	idpKey, idpCert := newSamlKeyPair(t, "idp")
+	idp := &saml.IdentityProvider{
+		Key:         idpKey,
+		Certificate: idpCert,
+		MetadataURL: url.URL{Scheme: "https", Host: "idp.example.com", Path: "/metadata"},
+		SSOURL:      url.URL{Scheme: "https", Host: "idp.example.com", Path: "/sso"},
+	}
+
+	metadata, err := xml.Marshal(idp.Metadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spKey, spCert := newSamlKeyPair(t, "sp")
+
+	err = InitSaml(&config.Saml{
+		IdpMetadataFile:   writeFile(t, "idp.xml", metadata),
+		CertificateFile:   writeFile(t, "sp.crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: spCert.Raw})),
+		KeyFile:           writeFile(t, "sp.key", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(spKey)})),
+		UsernameAttribute: "uid",
+		EmailAttribute:    "email",
+		RoleAttribute:     "groups",
+		RoleMapping:       roleMapping,
+		AutoCreateUsers:   true,
+	}, &config.App{BaseURL: testAppURL})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		samlCfg = &config.Saml{}
+		samlSP = nil
+	})
+
+	return &fakeIdp{idp: idp}
+}
+
+// beginSamlLogin starts a login and returns its RelayState and the id of the
+// AuthnRequest.
+func beginSamlLogin(t *testing.T) (string, string) {
+	t.Helper()
+
+	redirectURL, err := BeginSamlLogin([]string{config.ScopeOpenId})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := url.Parse(redirectURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relayState := parsed.Query().Get("RelayState")
+
+	var request model.SamlRequest
+	if err := database.DB.Where("relay_state_hash = ?", HashToken(relayState)).First(&request).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return relayState, request.RequestId
+}
+
+// assertion is what the identity provider says about alice in answer to
+// requestId, the tests change it before it is signed.
+func (f *fakeIdp) assertion(requestId string, now time.Time) *saml.Assertion {
+	attribute := func(name string, values ...string) saml.Attribute {
+		attr := saml.Attribute{Name: name, NameFormat: "urn:oasis:names:tc:SAML:2.0:attrname-format:basic"}
+		for _, value := range values {
+			attr.Values = append(attr.Values, saml.AttributeValue{Type: "xs:string", Value: value})
+		}
+
+		return attr
+	}
+
+	return &saml.Assertion{
+		ID:           fmt.Sprintf("id-%d", now.UnixNano()),
+		IssueInstant: now,
+		Version:      "2.0",
+		Issuer:       saml.Issuer{Format: "urn:oasis:names:tc:SAML:2.0:nameid-format:entity", Value: testIdpEntityID},
+		Subject: &saml.Subject{
+			NameID: &saml.NameID{Format: string(saml.PersistentNameIDFormat), Value: "alice-subject"},
+			SubjectConfirmations: []saml.SubjectConfirmation{{
+				Method: "urn:oasis:names:tc:SAML:2.0:cm:bearer",
+				SubjectConfirmationData: &saml.SubjectConfirmationData{
+					InResponseTo: requestId,
+					NotOnOrAfter: now.Add(5 * time.Minute),
+					Recipient:    testAppURL + "/saml/acs",
+				},
+			}},
+		},
+		Conditions: &saml.Conditions{
+			NotBefore:            now.Add(-time.Minute),
+			NotOnOrAfter:         now.Add(5 * time.Minute),
+			AudienceRestrictions: []saml.AudienceRestriction{{Audience: saml.Audience{Value: testAppURL + "/saml/metadata"}}},
+		},
+		AttributeStatements: []saml.AttributeStatement{{Attributes: []saml.Attribute{
+			attribute("uid", "alice"),
+			attribute("email", "alice@example.com"),
+			attribute("groups", "staff"),
+		}}},
+	}
+}
+
+// respond signs assertion and the response around it with the key of signer
+// and posts it to the assertion consumer service.
+func (f *fakeIdp) respond(t *testing.T, signer *saml.IdentityProvider, assertion *saml.Assertion, inResponseTo, relayState string) (*model.User, error) {
+	t.Helper()
+
+	req := &saml.IdpAuthnRequest{
+		IDP:             signer,
+		Request:         saml.AuthnRequest{ID: inResponseTo},
+		SPSSODescriptor: &saml.SPSSODescriptor{},
+		ACSEndpoint:     &saml.IndexedEndpoint{Binding: saml.HTTPPostBinding, Location: testAppURL + "/saml/acs"},
+		Assertion:       assertion,
+		Now:             assertion.IssueInstant,
+	}
+
+	if err := req.MakeResponse(); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := etree.NewDocument()
+	doc.SetRoot(req.ResponseEl)
+
+	response, err := doc.WriteToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form := url.Values{
+		"SAMLResponse": {base64.StdEncoding.EncodeToString(response)},
+		"RelayState":   {relayState},
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/saml/acs", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	user, _, err := CompleteSamlLogin(r)
+	return user, err
+}
+
+func TestSamlLogin(t *testing.T) {
+	idp := setupSaml(t, nil)
+	relayState, requestId := beginSamlLogin(t)
+
+	user, err := idp.respond(t, idp.idp, idp.assertion(requestId, time.Now()), requestId, relayState)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.Username != "alice" || user.Email != "alice@example.com" || !user.EmailVerified {
+		t.Fatalf("expected a verified account for alice, got %+v", user)
+	}
+}
+
+func TestSamlRejectsInvalidResponses(t *testing.T) {
+	otherKey, otherCert := newSamlKeyPair(t, "idp")
+
+	tests := []struct {
+		name string
+		// sign is given the trusted identity provider and returns the one
+		// that signs, modify changes the assertion before it is signed
+		sign         func(idp *saml.IdentityProvider) *saml.IdentityProvider
+		modify       func(assertion *saml.Assertion)
+		inResponseTo func(requestId string) string
+		now          time.Time
+	}{
+		{
+			name: "signed with another key",
+			sign: func(idp *saml.IdentityProvider) *saml.IdentityProvider {
+				forged := *idp
+				forged.Key, forged.Certificate = otherKey, otherCert
+				return &forged
+			},
+		},
+		{
+			name: "other audience",
+			modify: func(assertion *saml.Assertion) {
+				assertion.Conditions.AudienceRestrictions[0].Audience.Value = "https://other.example.com/saml/metadata"
+			},
+		},
+		{
+			name: "no audience",
+			modify: func(assertion *saml.Assertion) {
+				assertion.Conditions.AudienceRestrictions = nil
+			},
+		},
+		{
+			name: "expired",
+			now:  time.Now().Add(-time.Hour),
+		},
+		{
+			name:         "answers another request",
+			inResponseTo: func(requestId string) string { return "id-someone-else" },
+			modify: func(assertion *saml.Assertion) {
+				assertion.Subject.SubjectConfirmations[0].SubjectConfirmationData.InResponseTo = "id-someone-else"
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			idp := setupSaml(t, nil)
+			relayState, requestId := beginSamlLogin(t)
+
+			now := test.now
+			if now.IsZero() {
+				now = time.Now()
+			}
+
+			assertion := idp.assertion(requestId, now)
+			if test.modify != nil {
+				test.modify(assertion)
+			}
+
+			signer := idp.idp
+			if test.sign != nil {
+				signer = test.sign(idp.idp)
+			}
+
+			inResponseTo := requestId
+			if test.inResponseTo != nil {
+				inResponseTo = test.inResponseTo(requestId)
+			}
+
+			if _, err := idp.respond(t, signer, assertion, inResponseTo, relayState); !errors.Is(err, ErrInvalidSamlAssertion) {
+				t.Fatalf("expected ErrInvalidSamlAssertion, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSamlRelayStateIsSingleUse(t *testing.T) {
+	idp := setupSaml(t, nil)
+	relayState, requestId := beginSamlLogin(t)
+
+	if _, err := idp.respond(t, idp.idp, idp.assertion(requestId, time.Now()), requestId, relayState); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := idp.respond(t, idp.idp, idp.assertion(requestId, time.Now()), requestId, relayState); !errors.Is(err, ErrSamlRequest) {
+		t.Fatalf("expected the replayed RelayState to be refused, got %v", err)
+	}
+}
+
+func TestSamlSyncsRolesOnlyOfAccountsItCreated(t *testing.T) {
+	idp := setupSaml(t, map[string][]string{"staff": {config.RoleAdmin}})
+
+	// the last admin can not lose the role, so alice is not the only one
+	if err := AssignRoles(createExternalTestUser(t, "root", true), []string{config.RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+
+	relayState, requestId := beginSamlLogin(t)
+
+	user, err := idp.respond(t, idp.idp, idp.assertion(requestId, time.Now()), requestId, relayState)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if roles := user.RoleNames(); !reflect.DeepEqual(roles, []string{config.RoleAdmin}) {
+		t.Fatalf("expected the mapped role on the created account, got %v", roles)
+	}
+
+	// bob had an account before and is linked by his email, his roles are
+	// left to the admins
+	bob := createExternalTestUser(t, "bob", true)
+	if err := AssignRoles(bob, []string{config.DefaultRole}); err != nil {
+		t.Fatal(err)
+	}
+
+	relayState, requestId = beginSamlLogin(t)
+
+	assertion := idp.assertion(requestId, time.Now())
+	assertion.Subject.NameID.Value = "bob-subject"
+	assertion.AttributeStatements[0].Attributes[0].Values[0].Value = "bob"
+	assertion.AttributeStatements[0].Attributes[1].Values[0].Value = "bob@example.com"
+
+	user, err = idp.respond(t, idp.idp, assertion, requestId, relayState)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.Id != bob.Id {
+		t.Fatalf("expected the identity to be linked to bob, got user %d", user.Id)
+	}
+
+	if roles := user.RoleNames(); !reflect.DeepEqual(roles, []string{config.DefaultRole}) {
+		t.Fatalf("expected bob to keep his roles, got %v", roles)
+	}
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/yosikez/crudAuth/config"
+)
+
+var ErrInvalidScope = errors.New("unknown scope")
+
+// ParseScope reads a space separated scope parameter, an empty one means
+// config.DefaultScopes.
+func ParseScope(scope string) ([]string, error) {
+	fields := strings.Fields(scope)
+	if len(fields) == 0 {
+		return append([]string{}, config.DefaultScopes...), nil
+	}
+
+	if err := ValidateScopes(fields); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	scopes := make([]string, 0, len(fields))
+
+	for _, s := range fields {
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+
+	return scopes, nil
+}
+
+func ValidateScopes(scopes []string) error {
+	for _, s := range scopes {
+		if !isKnownScope(s) {
+			return ErrInvalidScope
+		}
+	}
+
+	return nil
+}
+
+func isKnownScope(scope string) bool {
+	return HasScope(config.AllScopes, scope)
+}
+
+// HasScope reports whether scope is one of scopes.
+func HasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+func FormatScope(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
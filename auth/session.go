@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/model"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrSessionNotFound    = errors.New("session not found")
+	ErrRefreshTokenReused = errors.New("refresh token has already been used")
+	ErrRefreshTokenClient = errors.New("refresh token was issued to another client")
+)
+
+// HashToken returns the hex encoded sha256 of a token, which is what gets
+// stored instead of the token itself.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func CreateSession(user *model.User, sessionId, clientId, refreshToken, userAgent, ipAddress string) (*model.RefreshToken, error) {
+	session := model.RefreshToken{
+		Token:     HashToken(refreshToken),
+		UserId:    user.Id,
+		Username:  user.Username,
+		SessionId: sessionId,
+		ClientId:  clientId,
+		UserAgent: userAgent,
+		IpAddress: ipAddress,
+	}
+
+	if err := database.DB.Create(&session).Error; err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func GetSession(userId uint, sessionId string) (*model.RefreshToken, error) {
+	var session model.RefreshToken
+
+	err := database.DB.Where("user_id = ? AND session_id = ?", userId, sessionId).First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSessionNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func ListSessions(userId uint) ([]model.RefreshToken, error) {
+	var sessions []model.RefreshToken
+
+	if err := database.DB.Where("user_id = ?", userId).Order("last_used_at desc").Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// IsSessionActive reports whether the session an access token was issued
+// for still exists, so revoking a device also cuts off its access tokens.
+func IsSessionActive(sessionId string) (bool, error) {
+	var count int64
+
+	if err := database.DB.Model(&model.RefreshToken{}).Where("session_id = ?", sessionId).Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new token pair. A
+// refresh token can only be used once: presenting one that has already been
+// rotated means it was copied, so the whole session is revoked and
+// ErrRefreshTokenReused is returned together with the revoked session.
+// clientId is the oauth client presenting the token, "" for the first party
+// /refresh-token endpoint, and has to be the one the session belongs to.
+func RotateRefreshToken(refreshToken, clientId string) (session *model.RefreshToken, accessToken, newRefreshToken string, err error) {
+	claims, err := ParseRefreshToken(refreshToken)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	session, err = GetSession(claims.Id, claims.SessionId)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	if session.ClientId != clientId {
+		return nil, "", "", ErrRefreshTokenClient
+	}
+
+	tokenHash := HashToken(refreshToken)
+
+	if subtle.ConstantTimeCompare([]byte(session.Token), []byte(tokenHash)) != 1 {
+		return session, "", "", revokeReusedSession(session)
+	}
+
+	accessToken, newRefreshToken, err = RefreshTokens(refreshToken)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	// only swap the hash if nobody rotated the token in the meantime, so two
+	// concurrent requests with the same token cannot both succeed
+	result := database.DB.Model(&model.RefreshToken{}).
+		Where("id = ? AND token = ?", session.Id, tokenHash).
+		Updates(map[string]interface{}{
+			"token":        HashToken(newRefreshToken),
+			"last_used_at": time.Now(),
+		})
+
+	if result.Error != nil {
+		return nil, "", "", result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return session, "", "", revokeReusedSession(session)
+	}
+
+	return session, accessToken, newRefreshToken, nil
+}
+
+func revokeReusedSession(session *model.RefreshToken) error {
+	if err := DeleteSession(session.UserId, session.SessionId); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		return err
+	}
+
+	return ErrRefreshTokenReused
+}
+
+func DeleteSession(userId uint, sessionId string) error {
+	result := database.DB.Where("user_id = ? AND session_id = ?", userId, sessionId).Delete(&model.RefreshToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+func DeleteAllSessions(userId uint) error {
+	return database.DB.Where("user_id = ?", userId).Delete(&model.RefreshToken{}).Error
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/database/databasetest"
+	"github.com/yosikez/crudAuth/model"
+	"gorm.io/gorm"
+)
+
+// setupAuth gives the test a database and signing keys.
+func setupAuth(t *testing.T) {
+	t.Helper()
+
+	databasetest.Open(t)
+
+	if err := InitKeyRing(&config.JWT{SigningAlgorithm: "ES256", KeyRotationPeriod: config.DefaultKeyRotationPeriod}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func createTestUser(t *testing.T, username string) *model.User {
+	t.Helper()
+
+	user := model.User{
+		Username: username,
+		Email:    username + "@example.com",
+		Password: "correct horse battery staple",
+	}
+
+	if err := database.DB.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return &user
+}
+
+// startTestSession logs a user in the way the login endpoint does and
+// returns the user and the refresh token.
+func startTestSession(t *testing.T) (*model.User, string) {
+	t.Helper()
+
+	setupAuth(t)
+
+	user := createTestUser(t, "alice")
+
+	sessionId, err := NewSessionId()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, refreshToken, err := GenerateTokens(user, sessionId, "", config.DefaultScopes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CreateSession(user, sessionId, "", refreshToken, "test", "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	return user, refreshToken
+}
+
+func countSessions(t *testing.T, userId uint) int64 {
+	t.Helper()
+
+	var count int64
+	if err := database.DB.Model(&model.RefreshToken{}).Where("user_id = ?", userId).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return count
+}
+
+func TestRotateRefreshTokenReuseRevokesTheSession(t *testing.T) {
+	user, refreshToken := startTestSession(t)
+
+	_, _, rotated, err := RotateRefreshToken(refreshToken, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, _, _, err := RotateRefreshToken(refreshToken, "")
+	if !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+	}
+
+	if session == nil || session.UserId != user.Id {
+		t.Fatalf("expected the revoked session to be returned, got %+v", session)
+	}
+
+	if count := countSessions(t, user.Id); count != 0 {
+		t.Fatalf("expected the session to be revoked, %d left", count)
+	}
+
+	// the whole family goes, the token handed out by the rotation too
+	if _, _, _, err := RotateRefreshToken(rotated, ""); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("expected the rotated token to be dead, got %v", err)
+	}
+}
+
+func TestRotateRefreshTokenLosingTheRaceRevokesTheSession(t *testing.T) {
+	user, refreshToken := startTestSession(t)
+
+	// another request rotates the token between the hash check and the
+	// update, the hook runs before the update starts its transaction
+	raced := false
+	err := database.DB.Callback().Update().Before("gorm:begin_transaction").Register("test:race", func(tx *gorm.DB) {
+		if raced || tx.Statement.Table != "refresh_tokens" {
+			return
+		}
+		raced = true
+
+		if err := database.DB.Model(&model.RefreshToken{}).Where("user_id = ?", user.Id).Update("token", HashToken("other")).Error; err != nil {
+			t.Error(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, err := RotateRefreshToken(refreshToken, ""); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+	}
+
+	if !raced {
+		t.Fatal("expected the session to be updated")
+	}
+
+	if count := countSessions(t, user.Id); count != 0 {
+		t.Fatalf("expected the session to be revoked, %d left", count)
+	}
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/yosikez/crudAuth/config"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func newTotpSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TotpURI is the otpauth:// uri authenticator apps read from a QR code.
+func TotpURI(secret, accountName string) string {
+	label := url.PathEscape(config.TotpIssuer + ":" + accountName)
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", config.TotpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(config.TotpDigits))
+	query.Set("period", fmt.Sprint(int(config.TotpPeriod.Seconds())))
+
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(config.TotpPeriod.Seconds())
+}
+
+// totpCode computes the RFC 6238 code for the given time step.
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return "", err
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < config.TotpDigits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", config.TotpDigits, value%mod), nil
+}
+
+// matchTotp returns the time step the code belongs to, or -1 if it does not
+// match any step within the allowed skew.
+func matchTotp(secret, code string, now time.Time) (int64, error) {
+	current := totpStep(now)
+
+	for i := -config.TotpSkew; i <= config.TotpSkew; i++ {
+		step := current + int64(i)
+
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return -1, err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, nil
+		}
+	}
+
+	return -1, nil
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/helper/hasher"
+	"github.com/yosikez/crudAuth/model"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUserDisabled          = errors.New("account is disabled")
+	ErrPasswordResetRequired = errors.New("password reset required")
+)
+
+// CheckUserStatus returns an error when the user may not be issued tokens.
+func CheckUserStatus(user *model.User) error {
+	if user.IsDisabled {
+		return ErrUserDisabled
+	}
+
+	if user.PasswordResetRequired {
+		return ErrPasswordResetRequired
+	}
+
+	return nil
+}
+
+// SetUserDisabled disables or enables an account. Disabling also ends every
+// session of the user.
+func SetUserDisabled(user *model.User, disabled bool) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("is_disabled", disabled).Error; err != nil {
+			return err
+		}
+
+		if !disabled {
+			return nil
+		}
+
+		return tx.Where("user_id = ?", user.Id).Delete(&model.RefreshToken{}).Error
+	})
+}
+
+// RequirePasswordReset blocks logins until the user sets a new password and
+// ends every session of the user.
+func RequirePasswordReset(user *model.User) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("password_reset_required", true).Error; err != nil {
+			return err
+		}
+
+		return tx.Where("user_id = ?", user.Id).Delete(&model.RefreshToken{}).Error
+	})
+}
+
+// DeleteUser removes the user for good together with everything that
+// belongs to it. ImpersonationLog rows are kept on purpose, they are the
+// audit trail and carry the usernames.
+func DeleteUser(user *model.User) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		for _, owned := range []interface{}{&model.Todo{}, &model.RefreshToken{}, &model.WebauthnSession{}, &model.PasswordResetToken{}, &model.TotpCredential{}, &model.RecoveryCode{}, &model.WebauthnCredential{}, &model.PasswordHistory{}, &model.PersonalAccessToken{}, &model.OauthAuthorizationCode{}, &model.OauthDeviceCode{}, &model.ExternalIdentity{}} {
+			if err := tx.Where("user_id = ?", user.Id).Delete(owned).Error; err != nil {
+				return err
+			}
+		}
+
+		// failures are kept by username, a new account with the same name
+		// should not start out locked
+		if err := tx.Where("key = ?", usernameKey(user.Username)).Delete(&model.LoginFailure{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(user).Association("Roles").Clear(); err != nil {
+			return err
+		}
+
+		return tx.Delete(user).Error
+	})
+}
+
+var (
+	ErrInvalidPassword           = errors.New("current password is incorrect")
+	ErrPasswordManagedExternally = errors.New("the password of this account is managed by the directory")
+	ErrPasswordChanged           = errors.New("the password was changed in the meantime")
+)
+
+// setPassword hashes plain and stores it, every password written after the
+// account was created goes through here so none can end up in plaintext.
+// The update is limited to the hash user was loaded with, a password changed
+// in the meantime is not overwritten and ErrPasswordChanged is returned.
+func setPassword(tx *gorm.DB, user *model.User, plain string) error {
+	hash, err := model.HashPassword(plain)
+	if err != nil {
+		return err
+	}
+
+	result := tx.Model(user).Where("password = ?", user.Password).Update("password", hash)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrPasswordChanged
+	}
+
+	user.Password = hash
+
+	return nil
+}
+
+// ChangePassword checks the current password and the password policy before
+// storing the new one and ends every other session of the user.
+func ChangePassword(user *model.User, currentPassword, newPassword, currentSessionId string) error {
+	if user.AuthSource == config.AuthSourceLdap {
+		return ErrPasswordManagedExternally
+	}
+
+	if ok, err := hasher.Verify(user.Password, currentPassword); err != nil || !ok {
+		return ErrInvalidPassword
+	}
+
+	if err := CheckPasswordPolicy(newPassword, user); err != nil {
+		return err
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := setPassword(tx, user, newPassword); err != nil {
+			return err
+		}
+
+		if err := recordPassword(tx, user.Id, user.Password); err != nil {
+			return err
+		}
+
+		return tx.Where("user_id = ? AND session_id <> ?", user.Id, currentSessionId).Delete(&model.RefreshToken{}).Error
+	})
+}
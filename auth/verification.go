@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/model"
+)
+
+var (
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+	ErrVerificationThrottled    = errors.New("verification email was sent recently")
+)
+
+// GenerateEmailVerificationToken signs a token bound to the user's current
+// email, so it stops working if the email changes before it is used.
+func GenerateEmailVerificationToken(user *model.User) (string, error) {
+	jti, err := newTokenId()
+	if err != nil {
+		return "", err
+	}
+
+	claims := config.EmailVerificationClaims{
+		Email: user.Email,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Subject:   strconv.Itoa(int(user.Id)),
+			Issuer:    config.RefreshTokenIssuer,
+			Audience:  config.EmailVerificationAudience,
+			ExpiresAt: time.Now().Add(config.EmailVerificationTokenDuration).Unix(),
+		},
+	}
+
+	return SignToken(claims)
+}
+
+// MarkVerificationSent records that a verification email is about to be sent
+// and returns ErrVerificationThrottled if the previous one is too recent.
+func MarkVerificationSent(user *model.User) error {
+	now := time.Now()
+
+	if user.VerificationSentAt != nil && now.Sub(*user.VerificationSentAt) < config.EmailVerificationResendAfter {
+		return ErrVerificationThrottled
+	}
+
+	if err := database.DB.Model(user).Update("verification_sent_at", now).Error; err != nil {
+		return err
+	}
+
+	user.VerificationSentAt = &now
+
+	return nil
+}
+
+func VerifyEmail(verificationToken string) (*model.User, error) {
+	token, err := ParseToken(verificationToken, &config.EmailVerificationClaims{})
+	if err != nil {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	claims, ok := token.Claims.(*config.EmailVerificationClaims)
+	if !ok || !token.Valid || claims.Audience != config.EmailVerificationAudience {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	userId, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	var user model.User
+	if err := database.DB.First(&user, userId).Error; err != nil {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	if user.Email != claims.Email {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	if user.EmailVerified {
+		return &user, nil
+	}
+
+	if err := database.DB.Model(&user).Update("email_verified", true).Error; err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func FindUserByEmail(email string) (*model.User, error) {
+	var user model.User
+
+	if err := database.DB.Where("email = ?", email).First(&user).Error; err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,342 @@
+package auth
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/model"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrWebAuthnSessionNotFound = errors.New("passkey ceremony not found or expired")
+	ErrPasskeyNotFound         = errors.New("passkey not found")
+	ErrPasskeyCloned           = errors.New("passkey sign counter went backwards, the authenticator may be cloned")
+)
+
+var relyingParty *webauthn.WebAuthn
+
+func InitWebAuthn(cfg *config.WebAuthn) error {
+	rp, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.RPID,
+		RPDisplayName: cfg.RPDisplayName,
+		RPOrigins:     cfg.RPOrigins,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	relyingParty = rp
+
+	return nil
+}
+
+// webauthnUser adapts model.User to the webauthn.User interface. The user
+// handle is the user id, so discoverable logins can find the user again.
+type webauthnUser struct {
+	user        *model.User
+	credentials []model.WebauthnCredential
+}
+
+func (w *webauthnUser) WebAuthnID() []byte {
+	return userHandle(w.user.Id)
+}
+
+func (w *webauthnUser) WebAuthnName() string {
+	return w.user.Username
+}
+
+func (w *webauthnUser) WebAuthnDisplayName() string {
+	return w.user.Username
+}
+
+func (w *webauthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (w *webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(w.credentials))
+
+	for _, c := range w.credentials {
+		var transports []protocol.AuthenticatorTransport
+		for _, t := range strings.Split(c.Transports, ",") {
+			if t != "" {
+				transports = append(transports, protocol.AuthenticatorTransport(t))
+			}
+		}
+
+		credentials = append(credentials, webauthn.Credential{
+			ID:              c.CredentialId,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: c.BackupEligible,
+				BackupState:    c.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    c.AAGUID,
+				SignCount: c.SignCount,
+			},
+		})
+	}
+
+	return credentials
+}
+
+func userHandle(userId uint) []byte {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(userId))
+	return handle
+}
+
+func loadWebauthnUser(user *model.User) (*webauthnUser, error) {
+	credentials, err := ListPasskeys(user.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webauthnUser{user: user, credentials: credentials}, nil
+}
+
+func saveWebAuthnSession(userId uint, kind string, data *webauthn.SessionData) (string, error) {
+	id, err := newTokenId()
+	if err != nil {
+		return "", err
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	// expired ceremonies are cleaned up whenever a new one starts
+	if err := database.DB.Where("expires_at < ?", time.Now()).Delete(&model.WebauthnSession{}).Error; err != nil {
+		return "", err
+	}
+
+	session := model.WebauthnSession{
+		Id:        id,
+		UserId:    userId,
+		Kind:      kind,
+		Data:      string(encoded),
+		ExpiresAt: time.Now().Add(config.WebAuthnCeremonyDuration),
+	}
+
+	if err := database.DB.Create(&session).Error; err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// takeWebAuthnSession loads and deletes a ceremony, so every challenge can
+// only be answered once.
+func takeWebAuthnSession(id string, userId uint, kind string) (*webauthn.SessionData, error) {
+	var session model.WebauthnSession
+
+	err := database.DB.Where("id = ? AND user_id = ? AND kind = ? AND expires_at > ?", id, userId, kind, time.Now()).First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrWebAuthnSessionNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := database.DB.Delete(&session)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, ErrWebAuthnSessionNotFound
+	}
+
+	var data webauthn.SessionData
+	if err := json.Unmarshal([]byte(session.Data), &data); err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+func BeginPasskeyRegistration(user *model.User) (*protocol.CredentialCreation, string, error) {
+	wu, err := loadWebauthnUser(user)
+	if err != nil {
+		return nil, "", err
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(wu.credentials))
+	for _, credential := range wu.WebAuthnCredentials() {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+
+	options, data, err := relyingParty.BeginRegistration(wu,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	sessionId, err := saveWebAuthnSession(user.Id, config.WebAuthnRegistration, data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return options, sessionId, nil
+}
+
+func FinishPasskeyRegistration(user *model.User, sessionId, name string, r *http.Request) (*model.WebauthnCredential, error) {
+	data, err := takeWebAuthnSession(sessionId, user.Id, config.WebAuthnRegistration)
+	if err != nil {
+		return nil, err
+	}
+
+	wu, err := loadWebauthnUser(user)
+	if err != nil {
+		return nil, err
+	}
+
+	credential, err := relyingParty.FinishRegistration(wu, *data, r)
+	if err != nil {
+		return nil, err
+	}
+
+	transports := make([]string, 0, len(credential.Transport))
+	for _, t := range credential.Transport {
+		transports = append(transports, string(t))
+	}
+
+	if name == "" {
+		name = "passkey"
+	}
+
+	passkey := model.WebauthnCredential{
+		UserId:          user.Id,
+		Name:            name,
+		CredentialId:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      strings.Join(transports, ","),
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+	}
+
+	if err := database.DB.Create(&passkey).Error; err != nil {
+		return nil, err
+	}
+
+	return &passkey, nil
+}
+
+// BeginPasskeyLogin starts a discoverable login, the authenticator tells us
+// who the user is so no username has to be typed. A passkey replaces both the
+// password and the second factor, so user verification is required.
+func BeginPasskeyLogin() (*protocol.CredentialAssertion, string, error) {
+	options, data, err := relyingParty.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, "", err
+	}
+
+	sessionId, err := saveWebAuthnSession(0, config.WebAuthnLogin, data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return options, sessionId, nil
+}
+
+func FinishPasskeyLogin(sessionId string, r *http.Request) (*model.User, error) {
+	data, err := takeWebAuthnSession(sessionId, 0, config.WebAuthnLogin)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var found *webauthnUser
+
+	handler := func(rawID, handle []byte) (webauthn.User, error) {
+		if len(handle) != 8 {
+			return nil, ErrPasskeyNotFound
+		}
+
+		user, err := LoadUser(uint(binary.BigEndian.Uint64(handle)))
+		if err != nil {
+			return nil, ErrPasskeyNotFound
+		}
+
+		found, err = loadWebauthnUser(user)
+		if err != nil {
+			return nil, err
+		}
+
+		return found, nil
+	}
+
+	credential, err := relyingParty.ValidateDiscoverableLogin(handler, *data, parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	if credential.Authenticator.CloneWarning {
+		return nil, ErrPasskeyCloned
+	}
+
+	now := time.Now()
+	if err := database.DB.Model(&model.WebauthnCredential{}).
+		Where("user_id = ? AND credential_id = ?", found.user.Id, credential.ID).
+		Updates(map[string]interface{}{
+			"sign_count":   credential.Authenticator.SignCount,
+			"backup_state": credential.Flags.BackupState,
+			"last_used_at": now,
+		}).Error; err != nil {
+		return nil, err
+	}
+
+	if err := CheckUserStatus(found.user); err != nil {
+		return found.user, err
+	}
+
+	return found.user, nil
+}
+
+func ListPasskeys(userId uint) ([]model.WebauthnCredential, error) {
+	var credentials []model.WebauthnCredential
+
+	if err := database.DB.Where("user_id = ?", userId).Order("created_at").Find(&credentials).Error; err != nil {
+		return nil, err
+	}
+
+	return credentials, nil
+}
+
+func DeletePasskey(userId, id uint) error {
+	result := database.DB.Where("user_id = ? AND id = ?", userId, id).Delete(&model.WebauthnCredential{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrPasskeyNotFound
+	}
+
+	return nil
+}
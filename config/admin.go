@@ -0,0 +1,32 @@
+package config
+
+import (
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+// BootstrapAdmin is the account that gets the admin role on startup while
+// nobody has it. Email and Password are only needed when the account does
+// not exist yet.
+type BootstrapAdmin struct {
+	Username string
+	Email    string
+	Password string
+}
+
+func LoadBootstrapAdmin() (*BootstrapAdmin, error) {
+	err := godotenv.Load()
+
+	if err != nil {
+		log.Fatal("failed to load .env file")
+		return nil, err
+	}
+
+	return &BootstrapAdmin{
+		Username: os.Getenv("ADMIN_USERNAME"),
+		Email:    os.Getenv("ADMIN_EMAIL"),
+		Password: os.Getenv("ADMIN_PASSWORD"),
+	}, nil
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	UnverifiedUserAllow    = "allow"
+	UnverifiedUserReadOnly = "read-only"
+	UnverifiedUserBlock    = "block"
+
+	EmailVerificationTokenDuration = 24 * time.Hour
+	EmailVerificationAudience      = "email-verification"
+	EmailVerificationResendAfter   = time.Minute
+
+	PasswordResetTokenDuration = 30 * time.Minute
+	PasswordResetResendAfter   = time.Minute
+)
+
+type App struct {
+	BaseURL string
+	// UnverifiedUserPolicy decides what users who have not verified their
+	// email yet may do on the /api routes.
+	UnverifiedUserPolicy string
+}
+
+func (a *App) URL(path string) string {
+	return strings.TrimRight(a.BaseURL, "/") + path
+}
+
+// Issuer identifies this service in id tokens and the openid connect
+// discovery document, it is the base url without a trailing slash.
+func (a *App) Issuer() string {
+	return a.URL("")
+}
+
+func LoadApp() (*App, error) {
+	err := godotenv.Load()
+
+	if err != nil {
+		log.Fatal("failed to load .env file")
+		return nil, err
+	}
+
+	appConfig := &App{
+		BaseURL:              os.Getenv("APP_URL"),
+		UnverifiedUserPolicy: os.Getenv("UNVERIFIED_USER_POLICY"),
+	}
+
+	if appConfig.BaseURL == "" {
+		appConfig.BaseURL = "http://localhost:8000"
+	}
+
+	switch appConfig.UnverifiedUserPolicy {
+	case "":
+		appConfig.UnverifiedUserPolicy = UnverifiedUserAllow
+	case UnverifiedUserAllow, UnverifiedUserReadOnly, UnverifiedUserBlock:
+	default:
+		return nil, fmt.Errorf("unsupported UNVERIFIED_USER_POLICY %q", appConfig.UnverifiedUserPolicy)
+	}
+
+	return appConfig, nil
+}
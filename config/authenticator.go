@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	// AuthSourceLocal users have their password in the users table,
+	// AuthSourceLdap users are shadows of a directory entry
+	AuthSourceLocal = "local"
+	AuthSourceLdap  = "ldap"
+)
+
+// LoadAuthBackends returns the authenticators from AUTH_BACKENDS in the order
+// a login tries them, by default only the local one.
+func LoadAuthBackends() ([]string, error) {
+	err := godotenv.Load()
+
+	if err != nil {
+		log.Fatal("failed to load .env file")
+		return nil, err
+	}
+
+	var backends []string
+
+	for _, backend := range strings.Split(os.Getenv("AUTH_BACKENDS"), ",") {
+		if backend = strings.TrimSpace(backend); backend == "" {
+			continue
+		}
+
+		switch backend {
+		case AuthSourceLocal, AuthSourceLdap:
+		default:
+			return nil, fmt.Errorf("unsupported AUTH_BACKENDS entry %q", backend)
+		}
+
+		for _, b := range backends {
+			if b == backend {
+				return nil, fmt.Errorf("duplicate AUTH_BACKENDS entry %q", backend)
+			}
+		}
+
+		backends = append(backends, backend)
+	}
+
+	if len(backends) == 0 {
+		backends = []string{AuthSourceLocal}
+	}
+
+	return backends, nil
+}
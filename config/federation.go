@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	// ExternalLoginDuration is how long a user has to finish the login at
+	// the external provider
+	ExternalLoginDuration = 10 * time.Minute
+	ExternalLoginCookie   = "external_login"
+
+	ExternalProviderTimeout = 10 * time.Second
+	// ExternalProviderCacheDuration is how long a provider's discovery
+	// document and keys are kept before they are fetched again
+	ExternalProviderCacheDuration = time.Hour
+)
+
+var externalProviderName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// ExternalProvider is an openid connect provider users can log in with.
+type ExternalProvider struct {
+	Name         string
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	Scopes       []string
+}
+
+type Federation struct {
+	Providers []*ExternalProvider
+	// AutoCreateUsers creates an account on the first login of an identity
+	// whose verified email does not belong to an account yet
+	AutoCreateUsers bool
+}
+
+func (f *Federation) Provider(name string) *ExternalProvider {
+	for _, provider := range f.Providers {
+		if provider.Name == name {
+			return provider
+		}
+	}
+
+	return nil
+}
+
+// LoadFederation reads the providers listed in OIDC_PROVIDERS, each one is
+// configured with OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET and _SCOPES.
+func LoadFederation() (*Federation, error) {
+	err := godotenv.Load()
+
+	if err != nil {
+		log.Fatal("failed to load .env file")
+		return nil, err
+	}
+
+	federationConfig := &Federation{
+		AutoCreateUsers: true,
+	}
+
+	if autoCreate := os.Getenv("OIDC_AUTO_CREATE_USERS"); autoCreate != "" {
+		b, err := strconv.ParseBool(autoCreate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OIDC_AUTO_CREATE_USERS %q", autoCreate)
+		}
+
+		federationConfig.AutoCreateUsers = b
+	}
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+
+		if !externalProviderName.MatchString(name) || federationConfig.Provider(name) != nil {
+			return nil, fmt.Errorf("invalid OIDC_PROVIDERS entry %q", name)
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+
+		provider := &ExternalProvider{
+			Name:         name,
+			Issuer:       strings.TrimRight(os.Getenv(prefix+"ISSUER"), "/"),
+			ClientId:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		}
+
+		if provider.Issuer == "" {
+			return nil, fmt.Errorf("missing %sISSUER", prefix)
+		}
+
+		if provider.ClientId == "" {
+			return nil, fmt.Errorf("missing %sCLIENT_ID", prefix)
+		}
+
+		if len(provider.Scopes) == 0 {
+			provider.Scopes = []string{"openid", "email", "profile"}
+		}
+
+		federationConfig.Providers = append(federationConfig.Providers, provider)
+	}
+
+	return federationConfig, nil
+}
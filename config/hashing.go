@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	HashAlgorithmArgon2id = "argon2id"
+	HashAlgorithmBcrypt   = "bcrypt"
+
+	DefaultHashAlgorithm = HashAlgorithmArgon2id
+	// argon2id defaults from the owasp password storage cheat sheet, memory is
+	// in KiB
+	DefaultArgon2Memory      = 19 * 1024
+	DefaultArgon2Iterations  = 2
+	DefaultArgon2Parallelism = 1
+	DefaultBcryptCost        = 12
+)
+
+// PasswordHashing selects how new password hashes are made. Hashes made with
+// another algorithm or weaker parameters keep working and are replaced the
+// next time the user logs in.
+type PasswordHashing struct {
+	Algorithm         string
+	Argon2Memory      uint32
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+	BcryptCost        int
+}
+
+func LoadPasswordHashing() (*PasswordHashing, error) {
+	err := godotenv.Load()
+
+	if err != nil {
+		log.Fatal("failed to load .env file")
+		return nil, err
+	}
+
+	hashing := &PasswordHashing{
+		Algorithm:         os.Getenv("PASSWORD_HASH_ALGORITHM"),
+		Argon2Memory:      DefaultArgon2Memory,
+		Argon2Iterations:  DefaultArgon2Iterations,
+		Argon2Parallelism: DefaultArgon2Parallelism,
+		BcryptCost:        DefaultBcryptCost,
+	}
+
+	switch hashing.Algorithm {
+	case "":
+		hashing.Algorithm = DefaultHashAlgorithm
+	case HashAlgorithmArgon2id, HashAlgorithmBcrypt:
+	default:
+		return nil, fmt.Errorf("unsupported PASSWORD_HASH_ALGORITHM %q", hashing.Algorithm)
+	}
+
+	if value := os.Getenv("ARGON2_MEMORY"); value != "" {
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || n < 8 {
+			return nil, fmt.Errorf("invalid ARGON2_MEMORY %q", value)
+		}
+
+		hashing.Argon2Memory = uint32(n)
+	}
+
+	if value := os.Getenv("ARGON2_ITERATIONS"); value != "" {
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid ARGON2_ITERATIONS %q", value)
+		}
+
+		hashing.Argon2Iterations = uint32(n)
+	}
+
+	if value := os.Getenv("ARGON2_PARALLELISM"); value != "" {
+		n, err := strconv.ParseUint(value, 10, 8)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid ARGON2_PARALLELISM %q", value)
+		}
+
+		hashing.Argon2Parallelism = uint8(n)
+	}
+
+	if value := os.Getenv("BCRYPT_COST"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 4 || n > 31 {
+			return nil, fmt.Errorf("invalid BCRYPT_COST %q", value)
+		}
+
+		hashing.BcryptCost = n
+	}
+
+	return hashing, nil
+}
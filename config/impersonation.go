@@ -0,0 +1,28 @@
+package config
+
+import "time"
+
+const (
+	// ImpersonationDuration is kept short, an impersonation token has no
+	// refresh token, support asks for a new one when it runs out
+	ImpersonationDuration = 10 * time.Minute
+	// ImpersonatedByHeader is set on every response to an impersonation
+	// token, with the username of the admin behind it
+	ImpersonatedByHeader = "X-Impersonated-By"
+)
+
+// ImpersonationScopes are what support needs to see what the user sees. They
+// leave out account and admin, so the token can not touch credentials or
+// impersonate further.
+var ImpersonationScopes = []string{
+	ScopeTodosRead,
+	ScopeTodosWrite,
+	ScopeProfile,
+}
+
+// ActorClaim is the act claim of rfc 8693, it names the admin acting as the
+// token's user.
+type ActorClaim struct {
+	Subject  string `json:"sub"`
+	Username string `json:"username"`
+}
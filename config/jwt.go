@@ -1,10 +1,13 @@
 package config
 
 import (
+	"fmt"
+	"log"
 	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt"
+	"github.com/joho/godotenv"
 )
 
 const (
@@ -12,13 +15,84 @@ const (
 	RefreshTokenDuration = 7 * 24 * time.Hour
 	RefreshTokenIssuer   = "crud-auth"
 	RefreshTokenAudience = "user"
+	AccessTokenAudience  = "access"
+
+	RevokedTokenPurgeInterval = time.Hour
+
+	// a new signing key is published this long before it starts signing so
+	// services caching the jwks have picked it up by then
+	SigningKeyPublishAhead   = 24 * time.Hour
+	SigningKeyCheckInterval  = time.Hour
+	DefaultSigningAlgorithm  = "RS256"
+	DefaultKeyRotationPeriod = 30 * 24 * time.Hour
+
+	// an unknown kid reloads the keys at most this often, new keys are
+	// published a day ahead so a short delay never rejects a valid token
+	SigningKeyMissReloadInterval = 5 * time.Second
 )
 
-var SecretKey = os.Getenv("SECRET_KEY")
+type JWT struct {
+	SigningAlgorithm  string
+	KeyRotationPeriod time.Duration
+}
 
 type Claims struct {
-	Id       uint   `json:"id"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
+	Id            uint     `json:"id"`
+	Username      string   `json:"username"`
+	Email         string   `json:"email"`
+	SessionId     string   `json:"sid,omitempty"`
+	EmailVerified bool     `json:"email_verified"`
+	Roles         []string `json:"roles,omitempty"`
+	Permissions   []string `json:"permissions,omitempty"`
+	// Scope is the space separated list of scopes granted to the token
+	Scope string `json:"scope,omitempty"`
+	// ClientId is set on tokens issued to an oauth client
+	ClientId string `json:"client_id,omitempty"`
+	// Act is set on impersonation tokens, it is the admin acting as the user
+	Act *ActorClaim `json:"act,omitempty"`
+	jwt.StandardClaims
+}
+
+func LoadJWT() (*JWT, error) {
+	err := godotenv.Load()
+
+	if err != nil {
+		log.Fatal("failed to load .env file")
+		return nil, err
+	}
+
+	jwtConfig := &JWT{
+		SigningAlgorithm:  os.Getenv("JWT_SIGNING_ALGORITHM"),
+		KeyRotationPeriod: DefaultKeyRotationPeriod,
+	}
+
+	if jwtConfig.SigningAlgorithm == "" {
+		jwtConfig.SigningAlgorithm = DefaultSigningAlgorithm
+	}
+
+	switch jwtConfig.SigningAlgorithm {
+	case "RS256", "ES256", "EdDSA":
+	default:
+		return nil, fmt.Errorf("unsupported jwt signing algorithm %q", jwtConfig.SigningAlgorithm)
+	}
+
+	if period := os.Getenv("JWT_KEY_ROTATION_PERIOD"); period != "" {
+		d, err := time.ParseDuration(period)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT_KEY_ROTATION_PERIOD : %v", err)
+		}
+
+		if d <= SigningKeyPublishAhead {
+			return nil, fmt.Errorf("JWT_KEY_ROTATION_PERIOD must be longer than %s", SigningKeyPublishAhead)
+		}
+
+		jwtConfig.KeyRotationPeriod = d
+	}
+
+	return jwtConfig, nil
+}
+
+type EmailVerificationClaims struct {
+	Email string `json:"email"`
 	jwt.StandardClaims
 }
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+const LdapTimeout = 10 * time.Second
+
+type Ldap struct {
+	// URL is ldap://host:389 or ldaps://host:636
+	URL string
+	// StartTLS upgrades an ldap:// connection before anything is sent
+	StartTLS           bool
+	InsecureSkipVerify bool
+	// BindDN and BindPassword are the service account that searches for
+	// users, anonymous search is used when they are empty
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter has one %s for the escaped username, for active directory
+	// use (sAMAccountName=%s)
+	UserFilter        string
+	UsernameAttribute string
+	EmailAttribute    string
+	GroupAttribute    string
+	// GroupRoles maps lowercased group dns to role names. When it is empty
+	// roles are left alone after the default role given on first login,
+	// otherwise they are replaced on every login.
+	GroupRoles map[string][]string
+}
+
+// LoadLdap reads the LDAP_* variables. LDAP_GROUP_ROLES is a | separated
+// list of <group dn>:<role> entries, a group may appear more than once.
+func LoadLdap() (*Ldap, error) {
+	err := godotenv.Load()
+
+	if err != nil {
+		log.Fatal("failed to load .env file")
+		return nil, err
+	}
+
+	ldapConfig := &Ldap{
+		URL:               os.Getenv("LDAP_URL"),
+		BindDN:            os.Getenv("LDAP_BIND_DN"),
+		BindPassword:      os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:            os.Getenv("LDAP_BASE_DN"),
+		UserFilter:        os.Getenv("LDAP_USER_FILTER"),
+		UsernameAttribute: os.Getenv("LDAP_USERNAME_ATTRIBUTE"),
+		EmailAttribute:    os.Getenv("LDAP_EMAIL_ATTRIBUTE"),
+		GroupAttribute:    os.Getenv("LDAP_GROUP_ATTRIBUTE"),
+		GroupRoles:        map[string][]string{},
+	}
+
+	if ldapConfig.URL == "" {
+		return nil, fmt.Errorf("missing LDAP_URL")
+	}
+
+	if ldapConfig.BaseDN == "" {
+		return nil, fmt.Errorf("missing LDAP_BASE_DN")
+	}
+
+	if ldapConfig.UserFilter == "" {
+		ldapConfig.UserFilter = "(uid=%s)"
+	}
+
+	if strings.Count(ldapConfig.UserFilter, "%s") != 1 {
+		return nil, fmt.Errorf("invalid LDAP_USER_FILTER %q", ldapConfig.UserFilter)
+	}
+
+	if ldapConfig.UsernameAttribute == "" {
+		ldapConfig.UsernameAttribute = "uid"
+	}
+
+	if ldapConfig.EmailAttribute == "" {
+		ldapConfig.EmailAttribute = "mail"
+	}
+
+	if ldapConfig.GroupAttribute == "" {
+		ldapConfig.GroupAttribute = "memberOf"
+	}
+
+	for name, target := range map[string]*bool{
+		"LDAP_START_TLS":            &ldapConfig.StartTLS,
+		"LDAP_INSECURE_SKIP_VERIFY": &ldapConfig.InsecureSkipVerify,
+	} {
+		if value := os.Getenv(name); value != "" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q", name, value)
+			}
+
+			*target = b
+		}
+	}
+
+	for _, entry := range strings.Split(os.Getenv("LDAP_GROUP_ROLES"), "|") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		i := strings.LastIndex(entry, ":")
+		if i <= 0 || i == len(entry)-1 {
+			return nil, fmt.Errorf("invalid LDAP_GROUP_ROLES entry %q", entry)
+		}
+
+		group := strings.ToLower(strings.TrimSpace(entry[:i]))
+		ldapConfig.GroupRoles[group] = append(ldapConfig.GroupRoles[group], strings.TrimSpace(entry[i+1:]))
+	}
+
+	return ldapConfig, nil
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	// failures older than this no longer count
+	LoginFailureWindow = 15 * time.Minute
+	// the first few failures are free, after that every attempt has to wait
+	// LoginDelayBase doubled per extra failure, up to LoginDelayMax
+	LoginDelayAfter = 3
+	LoginDelayBase  = time.Second
+	LoginDelayMax   = 30 * time.Second
+
+	DefaultLockoutThreshold = 10
+	DefaultLockoutDuration  = 15 * time.Minute
+
+	LoginFailurePurgeInterval = time.Hour
+)
+
+type LoginProtection struct {
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// TrustedProxies are the addresses or cidr ranges whose X-Forwarded-For
+	// is believed. Failures are counted per client ip, so when anyone could
+	// set the header the per ip limit would be one new header away. Empty
+	// means the connecting address is the client.
+	TrustedProxies []string
+}
+
+func LoadLoginProtection() (*LoginProtection, error) {
+	err := godotenv.Load()
+
+	if err != nil {
+		log.Fatal("failed to load .env file")
+		return nil, err
+	}
+
+	loginConfig := &LoginProtection{
+		LockoutThreshold: DefaultLockoutThreshold,
+		LockoutDuration:  DefaultLockoutDuration,
+	}
+
+	if threshold := os.Getenv("LOGIN_LOCKOUT_THRESHOLD"); threshold != "" {
+		n, err := strconv.Atoi(threshold)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid LOGIN_LOCKOUT_THRESHOLD %q", threshold)
+		}
+
+		loginConfig.LockoutThreshold = n
+	}
+
+	if duration := os.Getenv("LOGIN_LOCKOUT_DURATION"); duration != "" {
+		d, err := time.ParseDuration(duration)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid LOGIN_LOCKOUT_DURATION %q", duration)
+		}
+
+		loginConfig.LockoutDuration = d
+	}
+
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy == "" {
+			continue
+		}
+
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q", proxy)
+		}
+
+		loginConfig.TrustedProxies = append(loginConfig.TrustedProxies, proxy)
+	}
+
+	return loginConfig, nil
+}
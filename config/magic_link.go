@@ -0,0 +1,23 @@
+package config
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	MagicLinkDuration = 15 * time.Minute
+	MagicLinkAudience = "magic-link"
+	// MagicLinkResendAfter keeps the endpoint from being used to flood an
+	// inbox with login emails
+	MagicLinkResendAfter = time.Minute
+)
+
+// MagicLinkClaims are bound to the email the link was sent to, like the
+// verification token, and keep the scope asked for.
+type MagicLinkClaims struct {
+	Email string `json:"email"`
+	Scope string `json:"scope,omitempty"`
+	jwt.StandardClaims
+}
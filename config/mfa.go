@@ -0,0 +1,28 @@
+package config
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	TotpIssuer = "crudAuth"
+	TotpDigits = 6
+	TotpPeriod = 30 * time.Second
+	// codes from this many periods before or after now are still accepted to
+	// allow for clock drift on the authenticator
+	TotpSkew = 1
+
+	RecoveryCodeCount = 10
+
+	MfaChallengeDuration = 5 * time.Minute
+	MfaChallengeAudience = "mfa-challenge"
+)
+
+// MfaChallengeClaims keep the scope asked for at login until the second
+// factor is checked.
+type MfaChallengeClaims struct {
+	Scope string `json:"scope,omitempty"`
+	jwt.StandardClaims
+}
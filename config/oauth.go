@@ -0,0 +1,82 @@
+package config
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/joho/godotenv"
+)
+
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+	GrantDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+
+	OAuthCodeDuration = time.Minute
+	// OAuthAuthorizeRequestDuration is how long the consent form stays valid
+	OAuthAuthorizeRequestDuration = 10 * time.Minute
+	OAuthAuthorizeAudience        = "oauth-authorize"
+	PkceMethodS256                = "S256"
+
+	// DeviceCodeDuration is how long a user has to enter a device's user
+	// code, DeviceCodeInterval is the polling interval a device starts with
+	// and DeviceCodeSlowDown what is added each time it polls too fast
+	DeviceCodeDuration = 10 * time.Minute
+	DeviceCodeInterval = 5 * time.Second
+	DeviceCodeSlowDown = 5 * time.Second
+	// DeviceUserCodeCharset has no vowels, so codes do not spell words, and
+	// no characters that are easily confused, rfc 8628 section 6.1
+	DeviceUserCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+	DeviceUserCodeLength  = 8
+	// DeviceUserCodeMaxFailedLookups is how many wrong codes may be entered,
+	// from any ip, while a code is pending. Past it the pending codes can no
+	// longer be found and their devices have to start over.
+	DeviceUserCodeMaxFailedLookups = 1000
+
+	// OAuthTestClientId is the public client seeded for local testing when
+	// OAUTH_TEST_CLIENT_REDIRECT_URI is set
+	OAuthTestClientId = "test-client"
+)
+
+// OAuthClientScopes are the scopes an oauth client can be registered with.
+// Credentials, sessions and the admin routes stay with first party logins, a
+// client the user allowed once must not be able to take the account over.
+var OAuthClientScopes = []string{
+	ScopeOpenId,
+	ScopeEmail,
+	ScopeTodosRead,
+	ScopeTodosWrite,
+	ScopeProfile,
+}
+
+type OAuth struct {
+	TestClientRedirectURI string
+}
+
+func LoadOAuth() (*OAuth, error) {
+	err := godotenv.Load()
+
+	if err != nil {
+		log.Fatal("failed to load .env file")
+		return nil, err
+	}
+
+	return &OAuth{
+		TestClientRedirectURI: os.Getenv("OAUTH_TEST_CLIENT_REDIRECT_URI"),
+	}, nil
+}
+
+// OAuthAuthorizeClaims carry an authorization request from the consent form
+// back to the server, signed so the form fields can not be changed.
+type OAuthAuthorizeClaims struct {
+	ClientId      string `json:"client_id"`
+	RedirectURI   string `json:"redirect_uri"`
+	Scope         string `json:"scope"`
+	State         string `json:"state,omitempty"`
+	CodeChallenge string `json:"code_challenge"`
+	Nonce         string `json:"nonce,omitempty"`
+	jwt.StandardClaims
+}
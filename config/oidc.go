@@ -0,0 +1,14 @@
+package config
+
+import "github.com/golang-jwt/jwt"
+
+// IdTokenClaims are the openid connect id token claims, the email and
+// profile claims are only set when their scope was granted.
+type IdTokenClaims struct {
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Nonce             string `json:"nonce,omitempty"`
+	AuthTime          int64  `json:"auth_time,omitempty"`
+	jwt.StandardClaims
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	DefaultPasswordMinLength   = 8
+	DefaultPasswordMaxLength   = 128
+	DefaultPasswordHistorySize = 5
+)
+
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// HistorySize is how many of the user's previous passwords can not be
+	// used again, 0 turns the check off.
+	HistorySize int
+	// BreachedCorpusDir holds breached password hashes split by sha1 prefix,
+	// one <PREFIX>.txt file per 5 hex characters with SUFFIX:COUNT lines as
+	// served by the pwned passwords range api. Empty turns the check off.
+	BreachedCorpusDir string
+}
+
+func LoadPasswordPolicy() (*PasswordPolicy, error) {
+	err := godotenv.Load()
+
+	if err != nil {
+		log.Fatal("failed to load .env file")
+		return nil, err
+	}
+
+	policy := &PasswordPolicy{
+		MinLength:         DefaultPasswordMinLength,
+		MaxLength:         DefaultPasswordMaxLength,
+		HistorySize:       DefaultPasswordHistorySize,
+		BreachedCorpusDir: os.Getenv("PASSWORD_BREACHED_CORPUS_DIR"),
+	}
+
+	ints := map[string]*int{
+		"PASSWORD_MIN_LENGTH":   &policy.MinLength,
+		"PASSWORD_MAX_LENGTH":   &policy.MaxLength,
+		"PASSWORD_HISTORY_SIZE": &policy.HistorySize,
+	}
+
+	for name, target := range ints {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid %s %q", name, value)
+		}
+
+		*target = n
+	}
+
+	if policy.MinLength < 1 || policy.MaxLength < policy.MinLength {
+		return nil, fmt.Errorf("invalid password length range %d-%d", policy.MinLength, policy.MaxLength)
+	}
+
+	bools := map[string]*bool{
+		"PASSWORD_REQUIRE_UPPER":  &policy.RequireUpper,
+		"PASSWORD_REQUIRE_LOWER":  &policy.RequireLower,
+		"PASSWORD_REQUIRE_DIGIT":  &policy.RequireDigit,
+		"PASSWORD_REQUIRE_SYMBOL": &policy.RequireSymbol,
+	}
+
+	for name, target := range bools {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q", name, value)
+		}
+
+		*target = b
+	}
+
+	return policy, nil
+}
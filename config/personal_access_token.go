@@ -0,0 +1,23 @@
+package config
+
+import "time"
+
+const (
+	// PersonalAccessTokenPrefix tells personal access tokens apart from jwt
+	// access tokens in the Authorization header
+	PersonalAccessTokenPrefix = "pat_"
+	// last_used_at is only written when it is older than this, so a busy
+	// script does not update the row on every request
+	PersonalAccessTokenTouchInterval = time.Minute
+	PersonalAccessTokenMaxDays       = 365
+)
+
+// PersonalAccessTokenScopes are the scopes a personal access token can be
+// made with, the account and admin routes refuse these tokens anyway.
+var PersonalAccessTokenScopes = []string{
+	ScopeOpenId,
+	ScopeEmail,
+	ScopeTodosRead,
+	ScopeTodosWrite,
+	ScopeProfile,
+}
@@ -0,0 +1,46 @@
+package config
+
+const (
+	PermissionTodosRead    = "todos:read"
+	PermissionTodosWrite   = "todos:write"
+	PermissionUsersRead    = "users:read"
+	PermissionUsersWrite   = "users:write"
+	PermissionRolesRead    = "roles:read"
+	PermissionRolesWrite   = "roles:write"
+	PermissionClientsRead  = "clients:read"
+	PermissionClientsWrite = "clients:write"
+
+	// PermissionUsersImpersonate allows getting a token that acts as another
+	// user, see ImpersonationScopes
+	PermissionUsersImpersonate = "users:impersonate"
+
+	RoleAdmin    = "admin"
+	RoleMember   = "member"
+	RoleReadOnly = "read-only"
+
+	// DefaultRole is given to every newly registered user.
+	DefaultRole = RoleMember
+)
+
+// DefaultRoles are seeded on startup, existing roles keep any extra
+// permissions that were granted to them.
+var DefaultRoles = map[string][]string{
+	RoleAdmin: {
+		PermissionTodosRead,
+		PermissionTodosWrite,
+		PermissionUsersRead,
+		PermissionUsersWrite,
+		PermissionUsersImpersonate,
+		PermissionRolesRead,
+		PermissionRolesWrite,
+		PermissionClientsRead,
+		PermissionClientsWrite,
+	},
+	RoleMember: {
+		PermissionTodosRead,
+		PermissionTodosWrite,
+	},
+	RoleReadOnly: {
+		PermissionTodosRead,
+	},
+}
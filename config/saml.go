@@ -0,0 +1,115 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	// SamlProvider is the provider name of saml identities in the
+	// external_identities table
+	SamlProvider = "saml"
+	// SamlRequestDuration is how long a user has to finish the login at the
+	// identity provider
+	SamlRequestDuration = 10 * time.Minute
+)
+
+type Saml struct {
+	IdpMetadataURL  string
+	IdpMetadataFile string
+	// EntityID defaults to the url of the metadata endpoint
+	EntityID string
+	// CertificateFile and KeyFile are the pem encoded rsa key pair the
+	// AuthnRequests are signed with, the certificate is in the metadata
+	CertificateFile string
+	KeyFile         string
+
+	UsernameAttribute string
+	EmailAttribute    string
+	RoleAttribute     string
+	// RoleMapping maps lowercased values of RoleAttribute to role names,
+	// when it is empty roles are not synced. Accounts that existed before
+	// and were linked by email are never synced.
+	RoleMapping     map[string][]string
+	AutoCreateUsers bool
+}
+
+// Enabled reports whether an identity provider is configured.
+func (s *Saml) Enabled() bool {
+	return s.IdpMetadataURL != "" || s.IdpMetadataFile != ""
+}
+
+// LoadSaml reads the SAML_* variables. SAML_ROLE_MAPPING is a | separated
+// list of <attribute value>:<role> entries, like LDAP_GROUP_ROLES.
+func LoadSaml() (*Saml, error) {
+	err := godotenv.Load()
+
+	if err != nil {
+		log.Fatal("failed to load .env file")
+		return nil, err
+	}
+
+	samlConfig := &Saml{
+		IdpMetadataURL:    os.Getenv("SAML_IDP_METADATA_URL"),
+		IdpMetadataFile:   os.Getenv("SAML_IDP_METADATA_FILE"),
+		EntityID:          os.Getenv("SAML_SP_ENTITY_ID"),
+		CertificateFile:   os.Getenv("SAML_SP_CERT_FILE"),
+		KeyFile:           os.Getenv("SAML_SP_KEY_FILE"),
+		UsernameAttribute: os.Getenv("SAML_USERNAME_ATTRIBUTE"),
+		EmailAttribute:    os.Getenv("SAML_EMAIL_ATTRIBUTE"),
+		RoleAttribute:     os.Getenv("SAML_ROLE_ATTRIBUTE"),
+		RoleMapping:       map[string][]string{},
+		AutoCreateUsers:   true,
+	}
+
+	if !samlConfig.Enabled() {
+		return samlConfig, nil
+	}
+
+	if samlConfig.CertificateFile == "" || samlConfig.KeyFile == "" {
+		return nil, fmt.Errorf("missing SAML_SP_CERT_FILE or SAML_SP_KEY_FILE")
+	}
+
+	if samlConfig.UsernameAttribute == "" {
+		samlConfig.UsernameAttribute = "uid"
+	}
+
+	if samlConfig.EmailAttribute == "" {
+		samlConfig.EmailAttribute = "email"
+	}
+
+	if samlConfig.RoleAttribute == "" {
+		samlConfig.RoleAttribute = "groups"
+	}
+
+	if autoCreate := os.Getenv("SAML_AUTO_CREATE_USERS"); autoCreate != "" {
+		b, err := strconv.ParseBool(autoCreate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SAML_AUTO_CREATE_USERS %q", autoCreate)
+		}
+
+		samlConfig.AutoCreateUsers = b
+	}
+
+	for _, entry := range strings.Split(os.Getenv("SAML_ROLE_MAPPING"), "|") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		i := strings.LastIndex(entry, ":")
+		if i <= 0 || i == len(entry)-1 {
+			return nil, fmt.Errorf("invalid SAML_ROLE_MAPPING entry %q", entry)
+		}
+
+		value := strings.ToLower(strings.TrimSpace(entry[:i]))
+		samlConfig.RoleMapping[value] = append(samlConfig.RoleMapping[value], strings.TrimSpace(entry[i+1:]))
+	}
+
+	return samlConfig, nil
+}
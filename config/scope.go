@@ -0,0 +1,51 @@
+package config
+
+// Scopes limit what a token may be used for on top of the user's
+// permissions, a token needs both to reach a route.
+const (
+	// ScopeOpenId asks for an id token, ScopeEmail adds the email claims to
+	// it and to /userinfo, ScopeProfile adds preferred_username
+	ScopeOpenId = "openid"
+	ScopeEmail  = "email"
+
+	ScopeTodosRead  = "todos:read"
+	ScopeTodosWrite = "todos:write"
+	// ScopeProfile is reading and updating /me, and the profile claims of
+	// openid connect
+	ScopeProfile = "profile"
+	// ScopeAccount covers credentials and sessions: password, mfa, passkeys,
+	// personal access tokens
+	ScopeAccount = "account"
+	// ScopeAdmin is needed for the /admin routes
+	ScopeAdmin = "admin"
+)
+
+var AllScopes = []string{
+	ScopeOpenId,
+	ScopeEmail,
+	ScopeTodosRead,
+	ScopeTodosWrite,
+	ScopeProfile,
+	ScopeAccount,
+	ScopeAdmin,
+}
+
+// DefaultScopes are granted when a login does not ask for fewer. It is a
+// copy, appending to one of the lists must not change the other.
+var DefaultScopes = append([]string(nil), AllScopes...)
+
+// ScopePermissions are the permissions each scope makes use of, a personal
+// access token only gets the permissions of its user its scopes cover.
+var ScopePermissions = map[string][]string{
+	ScopeTodosRead:  {PermissionTodosRead},
+	ScopeTodosWrite: {PermissionTodosWrite},
+	ScopeAdmin: {
+		PermissionUsersRead,
+		PermissionUsersWrite,
+		PermissionUsersImpersonate,
+		PermissionRolesRead,
+		PermissionRolesWrite,
+		PermissionClientsRead,
+		PermissionClientsWrite,
+	},
+}
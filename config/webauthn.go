@@ -0,0 +1,56 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	WebAuthnCeremonyDuration = 5 * time.Minute
+	WebAuthnRegistration     = "registration"
+	WebAuthnLogin            = "login"
+)
+
+type WebAuthn struct {
+	RPID          string
+	RPDisplayName string
+	RPOrigins     []string
+}
+
+func LoadWebAuthn() (*WebAuthn, error) {
+	err := godotenv.Load()
+
+	if err != nil {
+		log.Fatal("failed to load .env file")
+		return nil, err
+	}
+
+	webAuthnConfig := &WebAuthn{
+		RPID:          os.Getenv("WEBAUTHN_RP_ID"),
+		RPDisplayName: os.Getenv("WEBAUTHN_RP_NAME"),
+	}
+
+	if webAuthnConfig.RPID == "" {
+		webAuthnConfig.RPID = "localhost"
+	}
+
+	if webAuthnConfig.RPDisplayName == "" {
+		webAuthnConfig.RPDisplayName = "crudAuth"
+	}
+
+	for _, origin := range strings.Split(os.Getenv("WEBAUTHN_RP_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			webAuthnConfig.RPOrigins = append(webAuthnConfig.RPOrigins, origin)
+		}
+	}
+
+	if len(webAuthnConfig.RPOrigins) == 0 {
+		webAuthnConfig.RPOrigins = []string{"http://localhost:8000"}
+	}
+
+	return webAuthnConfig, nil
+}
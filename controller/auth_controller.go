@@ -1,21 +1,68 @@
 package controller
 
 import (
+	"embed"
+	"errors"
+	"html/template"
+	"log"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/yosikez/crudAuth/auth"
+	"github.com/yosikez/crudAuth/config"
 	"github.com/yosikez/crudAuth/database"
 	"github.com/yosikez/crudAuth/input"
 	"github.com/yosikez/crudAuth/model"
+	"github.com/yosikez/crudAuth/rabbitmq"
 
 	cusMessage "github.com/yosikez/custom-error-message"
 )
 
-type AuthController struct{}
+//go:embed templates/password_reset.html
+var passwordResetTemplates embed.FS
 
-func NewAuthController() *AuthController {
-	return &AuthController{}
+var passwordResetTemplate = template.Must(template.ParseFS(passwordResetTemplates, "templates/password_reset.html"))
+
+type AuthController struct {
+	rmq      *config.RabbitMQConnection
+	rmqCfg   *config.RabbitMQ
+	appCfg   *config.App
+	loginCfg *config.LoginProtection
+}
+
+type SecurityEvent struct {
+	Event      string    `json:"event"`
+	UserId     uint      `json:"user_id"`
+	Username   string    `json:"username"`
+	SessionId  string    `json:"session_id"`
+	IpAddress  string    `json:"ip_address"`
+	UserAgent  string    `json:"user_agent"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+type UserEvent struct {
+	Event       string     `json:"event"`
+	UserId      uint       `json:"user_id"`
+	Username    string     `json:"username"`
+	Email       string     `json:"email"`
+	Url         string     `json:"url,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at,omitempty"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+	OccurredAt  time.Time  `json:"occurred_at"`
+}
+
+func NewAuthController(rqConnection *config.RabbitMQConnection, rqConfig *config.RabbitMQ, appConfig *config.App, loginConfig *config.LoginProtection) *AuthController {
+	return &AuthController{
+		rmq:      rqConnection,
+		rmqCfg:   rqConfig,
+		appCfg:   appConfig,
+		loginCfg: loginConfig,
+	}
 }
 
 func (a *AuthController) Register(c *gin.Context) {
@@ -31,6 +78,18 @@ func (a *AuthController) Register(c *gin.Context) {
 		return
 	}
 
+	// roles and account state are never taken from the request
+	user.Roles = nil
+	user.IsDisabled = false
+	user.PasswordResetRequired = false
+	user.EmailVerified = false
+	user.AuthSource = config.AuthSourceLocal
+
+	if err := auth.CheckPasswordPolicy(user.Password, &user); err != nil {
+		passwordPolicyError(c, "password", "failed to create user", err)
+		return
+	}
+
 	if err := database.DB.Create(&user).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"message": "failed to create user",
@@ -39,7 +98,24 @@ func (a *AuthController) Register(c *gin.Context) {
 		return
 	}
 
-	accessToken, refreshToken, err := auth.GenerateTokens(user.Id, user.Username, user.Email)
+	if err := auth.RecordPassword(&user); err != nil {
+		log.Printf("failed to record password history of user %d : %v", user.Id, err)
+	}
+
+	if err := auth.AssignRoles(&user, []string{config.DefaultRole}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to assign default role",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	// the account exists at this point, a failed email can be resent later
+	if err := sendVerification(a.rmq, a.rmqCfg, a.appCfg, &user); err != nil {
+		log.Printf("failed to send verification email to user %d : %v", user.Id, err)
+	}
+
+	createdUser, err := auth.LoadUser(user.Id)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -49,9 +125,11 @@ func (a *AuthController) Register(c *gin.Context) {
 		return
 	}
 
-	if err := database.DB.Create(&model.RefreshToken{Token: refreshToken, UserId: user.Id}).Error; err != nil {
+	accessToken, refreshToken, err := a.startSession(c, createdUser, config.DefaultScopes)
+
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "failed to save refresh token",
+			"message": "failed",
 			"error":   err.Error(),
 		})
 		return
@@ -75,65 +153,353 @@ func (a *AuthController) Login(c *gin.Context) {
 		return
 	}
 
-	user, err := auth.AuthenticateUser(body.Username, body.Password)
+	scopes, err := auth.ParseScope(body.Scope)
 
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "validation error",
+			"errors": map[string]string{
+				"scope": err.Error(),
+			},
+		})
+		return
+	}
+
+	if !a.checkLoginAllowed(c, body.Username) {
+		return
+	}
+
+	user, err := auth.AuthenticateUser(body.Username, body.Password)
+
+	if errors.Is(err, auth.ErrUserDisabled) || errors.Is(err, auth.ErrPasswordResetRequired) {
+		c.JSON(http.StatusForbidden, gin.H{
 			"message": "failed to login",
-			"error":   "invalid credentials",
+			"error":   err.Error(),
 		})
 
 		return
 	}
 
-	accessToken, refreshToken, err := auth.GenerateTokens(user.Id, user.Username, user.Email)
+	// the password could not be checked, so the attempt does not count
+	if errors.Is(err, auth.ErrAuthenticatorUnavailable) {
+		a.forgiveLoginAttempt(c, body.Username)
+
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"message": "failed to login",
+			"error":   auth.ErrAuthenticatorUnavailable.Error(),
+		})
+
+		return
+	}
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		a.recordLoginFailure(c, body.Username, nil)
+
+		c.JSON(http.StatusBadRequest, gin.H{
 			"message": "failed to login",
-			"error":   err.Error(),
+			"error":   "invalid credentials",
 		})
 
 		return
 	}
 
-	existingToken, err := auth.GetRefrehToken(user.Id)
+	totpEnabled, err := auth.IsTotpEnabled(user.Id)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "failed to get refresh token",
+			"message": "failed to login",
 			"error":   err.Error(),
 		})
 
 		return
 	}
 
-	if existingToken != nil {
-		existingToken.Token = refreshToken
+	if totpEnabled {
+		// only this attempt is taken back, the failures stay until the code
+		// is right too, or knowing the password would reset code guessing
+		a.forgiveLoginAttempt(c, body.Username)
 
-		if err := database.DB.Save(&existingToken).Error; err != nil {
+		mfaToken, err := auth.GenerateMfaChallenge(user, scopes)
+
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "failed to update refresh token",
+				"message": "failed to login",
 				"error":   err.Error(),
 			})
 
 			return
 		}
-	} else {
-		newRefreshToken := model.RefreshToken{
-			UserId:   user.Id,
-			Token:    refreshToken,
-			Username: user.Username,
+
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+		})
+
+		return
+	}
+
+	a.resetLoginFailures(c, user)
+
+	accessToken, refreshToken, err := a.startSession(c, user, scopes)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to login",
+			"error":   err.Error(),
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+	})
+}
+
+// LoginMfa finishes a login that was answered with an mfa_token by checking
+// a totp or recovery code.
+func (a *AuthController) LoginMfa(c *gin.Context) {
+	var body input.LoginMfaInput
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		errFields := cusMessage.GetErrMess(err, body, nil)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "validation error",
+			"errors":  errFields,
+		})
+		return
+	}
+
+	userId, scopes, err := auth.ParseMfaChallenge(body.MfaToken)
+
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, auth.ErrInvalidMfaChallenge) {
+			status = http.StatusUnauthorized
 		}
 
-		if err := database.DB.Create(&newRefreshToken).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "failed to save refresh token",
+		c.JSON(status, gin.H{
+			"message": "failed to login",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	user, err := auth.LoadUser(userId)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to login",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	// codes are guessable too, so they count against the same limits as
+	// passwords
+	if !a.checkLoginAllowed(c, user.Username) {
+		return
+	}
+
+	if err := auth.VerifyMfaCode(userId, body.Code); err != nil {
+		if errors.Is(err, auth.ErrInvalidMfaCode) {
+			a.recordLoginFailure(c, user.Username, user)
+
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"message": "failed to login",
 				"error":   err.Error(),
 			})
+			return
+		}
 
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to login",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	// the account may have been disabled since the password step
+	if err := auth.CheckUserStatus(user); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"message": "failed to login",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	// used up only after the code was accepted, so a mistyped code can be
+	// retried with the same challenge
+	if err := auth.ConsumeMfaChallenge(body.MfaToken); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, auth.ErrInvalidMfaChallenge) {
+			status = http.StatusUnauthorized
+		}
+
+		c.JSON(status, gin.H{
+			"message": "failed to login",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	a.resetLoginFailures(c, user)
+
+	accessToken, refreshToken, err := a.startSession(c, user, scopes)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to login",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+	})
+}
+
+// checkLoginAllowed answers the request and returns false when the username
+// or the client ip has to wait before trying again.
+func (a *AuthController) checkLoginAllowed(c *gin.Context, username string) bool {
+	wait, err := auth.CheckLoginAllowed(username, c.ClientIP())
+
+	if err == nil {
+		return true
+	}
+
+	if errors.Is(err, auth.ErrAccountLocked) || errors.Is(err, auth.ErrLoginThrottled) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"message": "failed to login",
+			"error":   err.Error(),
+		})
+		return false
+	}
+
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"message": "failed to login",
+		"error":   err.Error(),
+	})
+	return false
+}
+
+// recordLoginFailure only logs when the lockout check fails, the client still
+// gets the invalid credentials answer. When the failure locks the account the
+// user is told about it, user is looked up by username when it is not known
+// yet.
+func (a *AuthController) recordLoginFailure(c *gin.Context, username string, user *model.User) {
+	lockedUntil, err := auth.RecordLoginFailure(a.loginCfg, username)
+
+	if err != nil {
+		log.Printf("failed to record login failure for %s : %v", username, err)
+		return
+	}
+
+	if lockedUntil == nil {
+		return
+	}
+
+	if user == nil {
+		var found model.User
+		if err := database.DB.Where("username = ?", username).First(&found).Error; err != nil {
 			return
 		}
+
+		user = &found
+	}
+
+	message := &UserEvent{
+		Event:       "user.locked",
+		UserId:      user.Id,
+		Username:    user.Username,
+		Email:       user.Email,
+		LockedUntil: lockedUntil,
+		OccurredAt:  time.Now(),
+	}
+
+	if err := rabbitmq.Publish(a.rmq, a.rmqCfg, message.Event, message); err != nil {
+		log.Printf("failed to publish user event %s : %v", message.Event, err)
+	}
+}
+
+// forgiveLoginAttempt takes back the failure checkLoginAllowed counted, for
+// credentials that were right.
+func (a *AuthController) forgiveLoginAttempt(c *gin.Context, username string) {
+	if err := auth.ForgiveLoginAttempt(username, c.ClientIP()); err != nil {
+		log.Printf("failed to forgive login attempt for %s : %v", username, err)
+	}
+}
+
+func (a *AuthController) resetLoginFailures(c *gin.Context, user *model.User) {
+	a.forgiveLoginAttempt(c, user.Username)
+
+	if err := auth.ResetLoginFailures(user.Username); err != nil {
+		log.Printf("failed to reset login failures for %s : %v", user.Username, err)
+	}
+}
+
+func (a *AuthController) BeginPasskeyLogin(c *gin.Context) {
+	options, sessionId, err := auth.BeginPasskeyLogin()
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to begin passkey login",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"session_id": sessionId,
+		"options":    options,
+	})
+}
+
+// FinishPasskeyLogin takes the authenticator response as the body, the
+// session_id from the begin step and an optional scope come in the query.
+func (a *AuthController) FinishPasskeyLogin(c *gin.Context) {
+	scopes, err := auth.ParseScope(c.Query("scope"))
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "validation error",
+			"errors": map[string]string{
+				"scope": err.Error(),
+			},
+		})
+		return
+	}
+
+	user, err := auth.FinishPasskeyLogin(c.Query("session_id"), c.Request)
+
+	if errors.Is(err, auth.ErrUserDisabled) || errors.Is(err, auth.ErrPasswordResetRequired) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"message": "failed to login",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "failed to login",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	accessToken, refreshToken, err := a.startSession(c, user, scopes)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to login",
+			"error":   err.Error(),
+		})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -143,9 +509,9 @@ func (a *AuthController) Login(c *gin.Context) {
 }
 
 func (a *AuthController) RefreshToken(c *gin.Context) {
-	var resfreshTokenRequest model.RefreshToken
+	var body input.RefreshTokenInput
 
-	if err := c.ShouldBindJSON(&resfreshTokenRequest); err != nil {
+	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"message": "invalid request payload",
 			"error":   err.Error(),
@@ -153,47 +519,149 @@ func (a *AuthController) RefreshToken(c *gin.Context) {
 		return
 	}
 
-	claims, err := auth.GetClaimsDataFromToken(c)
+	session, accessToken, refreshToken, err := auth.RotateRefreshToken(body.RefreshToken, "")
 
 	if err != nil {
+		if errors.Is(err, auth.ErrRefreshTokenReused) {
+			a.publishSecurityEvent(c, "refresh_token_reused", session)
+
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "refresh token has already been used, the session has been revoked",
+			})
+			return
+		}
+
+		if errors.Is(err, auth.ErrSessionNotFound) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "session has been revoked",
+			})
+			return
+		}
+
+		if errors.Is(err, auth.ErrUserDisabled) || errors.Is(err, auth.ErrPasswordResetRequired) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
 		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "unauthorized",
+			"error": err.Error(),
 		})
 		return
 	}
 
-	existingToken, err := auth.GetRefrehToken(claims.Id)
+	c.JSON(http.StatusOK, gin.H{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+	})
 
-	if err != nil {
+}
+
+func (a *AuthController) Logout(c *gin.Context) {
+	userId := c.GetUint("userId")
+
+	if err := auth.DeleteSession(userId, c.GetString("sessionId")); err != nil && !errors.Is(err, auth.ErrSessionNotFound) {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "internal server error",
+			"message": "failed to logout",
+			"error":   err.Error(),
 		})
 		return
 	}
 
-	if !existingToken.IsValid(resfreshTokenRequest.Token) {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid refresh token",
-			"test":  "test",
+	if err := auth.RevokeAccessToken(c.GetString("tokenId"), userId, c.GetInt64("tokenExpiresAt")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to logout",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "logged out successfully",
+	})
+}
+
+func (a *AuthController) LogoutAll(c *gin.Context) {
+	userId := c.GetUint("userId")
+
+	if err := auth.DeleteAllSessions(userId); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to logout from all sessions",
+			"error":   err.Error(),
 		})
 		return
 	}
 
-	accessToken, refreshToken, err := auth.RefreshTokens(resfreshTokenRequest.Token)
+	if err := auth.RevokeAccessToken(c.GetString("tokenId"), userId, c.GetInt64("tokenExpiresAt")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to logout from all sessions",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "logged out from all sessions successfully",
+	})
+}
+
+func (a *AuthController) startSession(c *gin.Context, user *model.User, scopes []string) (accessToken, refreshToken string, err error) {
+	sessionId, err := auth.NewSessionId()
+	if err != nil {
+		return "", "", err
+	}
+
+	accessToken, refreshToken, err = auth.GenerateTokens(user, sessionId, "", scopes)
+	if err != nil {
+		return "", "", err
+	}
+
+	if _, err := auth.CreateSession(user, sessionId, "", refreshToken, c.Request.UserAgent(), c.ClientIP()); err != nil {
+		return "", "", errors.New("failed to save refresh token")
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+// finishFederatedLogin answers a login done at an external identity provider
+// or with a login link the way Login does: they replace the password, not
+// the second factor.
+func (a *AuthController) finishFederatedLogin(c *gin.Context, user *model.User, scopes []string) {
+	totpEnabled, err := auth.IsTotpEnabled(user.Id)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": err.Error(),
+			"message": "failed to login",
+			"error":   err.Error(),
 		})
 		return
 	}
 
-	existingToken.Token = refreshToken
+	if totpEnabled {
+		mfaToken, err := auth.GenerateMfaChallenge(user, scopes)
 
-	if err := database.DB.Model(&existingToken).Updates(existingToken).Error; err != nil {
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "failed to login",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+		})
+		return
+	}
+
+	accessToken, refreshToken, err := a.startSession(c, user, scopes)
+
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to login",
 			"error":   err.Error(),
-			"message": "failed to update refresh token in the database",
 		})
 		return
 	}
@@ -202,5 +670,386 @@ func (a *AuthController) RefreshToken(c *gin.Context) {
 		"access_token":  accessToken,
 		"refresh_token": refreshToken,
 	})
+}
+
+// publishSecurityEvent only logs when publishing fails, the request that
+// triggered the event has already been handled.
+func (a *AuthController) publishSecurityEvent(c *gin.Context, event string, session *model.RefreshToken) {
+	message := &SecurityEvent{
+		Event:      event,
+		UserId:     session.UserId,
+		Username:   session.Username,
+		SessionId:  session.SessionId,
+		IpAddress:  c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+		OccurredAt: time.Now(),
+	}
+
+	if err := rabbitmq.Publish(a.rmq, a.rmqCfg, "security."+event, message); err != nil {
+		log.Printf("failed to publish security event %s : %v", event, err)
+	}
+}
+
+func (a *AuthController) VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "failed to verify email",
+			"error":   "token is required",
+		})
+		return
+	}
+
+	user, err := auth.VerifyEmail(token)
+
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidVerificationToken) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "failed to verify email",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to verify email",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "email verified successfully",
+		"email":   user.Email,
+	})
+}
+
+// ResendVerification answers the same way whether or not the email belongs
+// to an unverified account, so it cannot be used to probe for accounts.
+func (a *AuthController) ResendVerification(c *gin.Context) {
+	var body input.ResendVerificationInput
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		errFields := cusMessage.GetErrMess(err, body, nil)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "validation error",
+			"errors":  errFields,
+		})
+		return
+	}
+
+	user, err := auth.FindUserByEmail(body.Email)
+
+	if err == nil && !user.EmailVerified {
+		if err := sendVerification(a.rmq, a.rmqCfg, a.appCfg, user); err != nil && !errors.Is(err, auth.ErrVerificationThrottled) {
+			log.Printf("failed to send verification email to user %d : %v", user.Id, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "if the email belongs to an unverified account, a verification email has been sent",
+	})
+}
 
+// sendVerification is shared with ProfileController, which has to verify a
+// changed email again.
+func sendVerification(rmq *config.RabbitMQConnection, rmqCfg *config.RabbitMQ, appCfg *config.App, user *model.User) error {
+	if err := auth.MarkVerificationSent(user); err != nil {
+		return err
+	}
+
+	token, err := auth.GenerateEmailVerificationToken(user)
+	if err != nil {
+		return err
+	}
+
+	message := &UserEvent{
+		Event:      "user.verification_requested",
+		UserId:     user.Id,
+		Username:   user.Username,
+		Email:      user.Email,
+		Url:        appCfg.URL("/verify-email?token=" + url.QueryEscape(token)),
+		ExpiresAt:  time.Now().Add(config.EmailVerificationTokenDuration),
+		OccurredAt: time.Now(),
+	}
+
+	return rabbitmq.Publish(rmq, rmqCfg, message.Event, message)
+}
+
+// ForgotPassword always answers 200 so it cannot be used to find out which
+// emails have an account.
+func (a *AuthController) ForgotPassword(c *gin.Context) {
+	var body input.ForgotPasswordInput
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		errFields := cusMessage.GetErrMess(err, body, nil)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "validation error",
+			"errors":  errFields,
+		})
+		return
+	}
+
+	// directory users reset their password in the directory, the answer is
+	// the same so it does not tell which accounts they are
+	if user, err := auth.FindUserByEmail(body.Email); err == nil && user.AuthSource != config.AuthSourceLdap {
+		if err := a.sendPasswordReset(user); err != nil && !errors.Is(err, auth.ErrPasswordResetThrottled) {
+			log.Printf("failed to send password reset to user %d : %v", user.Id, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "if the email belongs to an account, a password reset email has been sent",
+	})
+}
+
+type passwordResetPage struct {
+	Token string
+	Error string
+	Done  string
+}
+
+// renderPasswordResetPage keeps the token out of caches and referers and the
+// page out of frames.
+func renderPasswordResetPage(c *gin.Context, status int, page passwordResetPage) {
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Content-Security-Policy", "frame-ancestors 'none'")
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(status)
+
+	if err := passwordResetTemplate.Execute(c.Writer, page); err != nil {
+		log.Printf("failed to render password reset page : %v", err)
+	}
+}
+
+// PasswordResetPage is where the emailed link points, its form posts the
+// token and the new password to ResetPassword.
+func (a *AuthController) PasswordResetPage(c *gin.Context) {
+	renderPasswordResetPage(c, http.StatusOK, passwordResetPage{Token: c.Query("token")})
+}
+
+// ResetPassword takes json from api clients and the form of
+// PasswordResetPage, which gets a page back instead of json.
+func (a *AuthController) ResetPassword(c *gin.Context) {
+	if c.ContentType() == binding.MIMEPOSTForm {
+		a.resetPasswordForm(c)
+		return
+	}
+
+	var body input.ResetPasswordInput
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		errFields := cusMessage.GetErrMess(err, body, nil)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "validation error",
+			"errors":  errFields,
+		})
+		return
+	}
+
+	if _, err := auth.ResetPassword(body.Token, body.Password); err != nil {
+		var policyErr *auth.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			passwordPolicyError(c, "password", "failed to reset password", err)
+			return
+		}
+
+		if errors.Is(err, auth.ErrInvalidResetToken) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "failed to reset password",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to reset password",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "password has been reset, please login again",
+	})
+}
+
+func (a *AuthController) resetPasswordForm(c *gin.Context) {
+	page := passwordResetPage{Token: c.PostForm("token")}
+	password := c.PostForm("password")
+
+	if page.Token == "" || password == "" {
+		page.Error = "token and password are required"
+		renderPasswordResetPage(c, http.StatusBadRequest, page)
+		return
+	}
+
+	if password != c.PostForm("password_confirmation") {
+		page.Error = "the passwords do not match"
+		renderPasswordResetPage(c, http.StatusBadRequest, page)
+		return
+	}
+
+	if _, err := auth.ResetPassword(page.Token, password); err != nil {
+		var policyErr *auth.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			page.Error = err.Error()
+			renderPasswordResetPage(c, http.StatusBadRequest, page)
+			return
+		}
+
+		// a used or expired token can not be retried, so the form goes away
+		if errors.Is(err, auth.ErrInvalidResetToken) {
+			renderPasswordResetPage(c, http.StatusBadRequest, passwordResetPage{Error: err.Error()})
+			return
+		}
+
+		page.Error = "failed to reset password"
+		renderPasswordResetPage(c, http.StatusInternalServerError, page)
+		return
+	}
+
+	renderPasswordResetPage(c, http.StatusOK, passwordResetPage{Done: "Your password has been reset, you can log in with it now."})
+}
+
+func (a *AuthController) sendPasswordReset(user *model.User) error {
+	token, expiresAt, err := auth.CreatePasswordResetToken(user)
+	if err != nil {
+		return err
+	}
+
+	message := &UserEvent{
+		Event:      "user.password_reset_requested",
+		UserId:     user.Id,
+		Username:   user.Username,
+		Email:      user.Email,
+		Url:        a.appCfg.URL("/password/reset?token=" + url.QueryEscape(token)),
+		ExpiresAt:  expiresAt,
+		OccurredAt: time.Now(),
+	}
+
+	return rabbitmq.Publish(a.rmq, a.rmqCfg, message.Event, message)
+}
+
+// MagicLogin emails a login link. Like ForgotPassword it answers the same
+// way whether or not the email has an account.
+func (a *AuthController) MagicLogin(c *gin.Context) {
+	var body input.MagicLinkInput
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		errFields := cusMessage.GetErrMess(err, body, nil)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "validation error",
+			"errors":  errFields,
+		})
+		return
+	}
+
+	scopes, err := auth.ParseScope(body.Scope)
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "validation error",
+			"errors": map[string]string{
+				"scope": err.Error(),
+			},
+		})
+		return
+	}
+
+	// directory users log in with their directory password only
+	if user, err := auth.FindUserByEmail(body.Email); err == nil && user.AuthSource != config.AuthSourceLdap {
+		if err := a.sendMagicLink(user, scopes); err != nil && !errors.Is(err, auth.ErrMagicLinkThrottled) {
+			log.Printf("failed to send login link to user %d : %v", user.Id, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "if the email belongs to an account, a login link has been sent",
+	})
+}
+
+// MagicLoginCallback exchanges a login link for tokens. The link replaces
+// the password, not the second factor.
+func (a *AuthController) MagicLoginCallback(c *gin.Context) {
+	token := c.Query("token")
+
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "failed to login",
+			"error":   "token is required",
+		})
+		return
+	}
+
+	user, scopes, err := auth.ConsumeMagicLink(token)
+
+	if errors.Is(err, auth.ErrInvalidMagicLink) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "failed to login",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if errors.Is(err, auth.ErrUserDisabled) || errors.Is(err, auth.ErrPasswordResetRequired) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"message": "failed to login",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to login",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	a.finishFederatedLogin(c, user, scopes)
+}
+
+func (a *AuthController) sendMagicLink(user *model.User, scopes []string) error {
+	token, expiresAt, err := auth.CreateMagicLinkToken(user, scopes)
+	if err != nil {
+		return err
+	}
+
+	message := &UserEvent{
+		Event:      "user.magic_link_requested",
+		UserId:     user.Id,
+		Username:   user.Username,
+		Email:      user.Email,
+		Url:        a.appCfg.URL("/login/magic/callback?token=" + url.QueryEscape(token)),
+		ExpiresAt:  expiresAt,
+		OccurredAt: time.Now(),
+	}
+
+	return rabbitmq.Publish(a.rmq, a.rmqCfg, message.Event, message)
+}
+
+// passwordPolicyError answers a password the policy refused like a failed
+// binding, with the violations under the password's json field, and any
+// other error as a server error.
+func passwordPolicyError(c *gin.Context, field, message string, err error) {
+	var policyErr *auth.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "validation error",
+			"errors": map[string]string{
+				field: policyErr.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"message": message,
+		"error":   err.Error(),
+	})
 }
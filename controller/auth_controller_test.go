@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yosikez/crudAuth/auth"
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/database/databasetest"
+	"github.com/yosikez/crudAuth/model"
+	"github.com/yosikez/crudAuth/rabbitmq"
+)
+
+// setupAuth gives the test a database and signing keys.
+func setupAuth(t *testing.T) {
+	t.Helper()
+
+	databasetest.Open(t)
+
+	if err := auth.InitKeyRing(&config.JWT{SigningAlgorithm: "ES256", KeyRotationPeriod: config.DefaultKeyRotationPeriod}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func createTestUser(t *testing.T, username string) *model.User {
+	t.Helper()
+
+	user := model.User{
+		Username:      username,
+		Email:         username + "@example.com",
+		Password:      "correct horse battery staple",
+		EmailVerified: true,
+	}
+
+	if err := database.DB.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return &user
+}
+
+func mustJSON(t *testing.T, value interface{}) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func doJSON(t *testing.T, router *gin.Engine, method, target string, body []byte) (int, map[string]interface{}) {
+	t.Helper()
+
+	request := httptest.NewRequest(method, target, bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("%s %s answered %d with %q", method, target, recorder.Code, recorder.Body.String())
+	}
+
+	return recorder.Code, response
+}
+
+// recordEvents swaps rabbitmq.Publish for the test and returns the routing
+// keys of what would have been sent.
+func recordEvents(t *testing.T) *[]string {
+	t.Helper()
+
+	events := &[]string{}
+
+	original := rabbitmq.Publish
+	rabbitmq.Publish = func(conn *config.RabbitMQConnection, cfg *config.RabbitMQ, routingKey string, payload interface{}) error {
+		*events = append(*events, routingKey)
+		return nil
+	}
+	t.Cleanup(func() {
+		rabbitmq.Publish = original
+	})
+
+	return events
+}
+
+func TestRefreshTokenReuseRevokesTheSession(t *testing.T) {
+	setupAuth(t)
+	events := recordEvents(t)
+
+	user := createTestUser(t, "alice")
+
+	sessionId, err := auth.NewSessionId()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, refreshToken, err := auth.GenerateTokens(user, sessionId, "", config.DefaultScopes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := auth.CreateSession(user, sessionId, "", refreshToken, "test", "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/refresh-token", (&AuthController{}).RefreshToken)
+
+	body := mustJSON(t, map[string]string{"refresh_token": refreshToken})
+
+	status, rotated := doJSON(t, router, http.MethodPost, "/refresh-token", body)
+	if status != http.StatusOK {
+		t.Fatalf("expected the first refresh to succeed, got %d %v", status, rotated)
+	}
+
+	status, response := doJSON(t, router, http.MethodPost, "/refresh-token", body)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("expected the reused token to be refused, got %d %v", status, response)
+	}
+
+	if len(*events) != 1 || (*events)[0] != "security.refresh_token_reused" {
+		t.Fatalf("expected one refresh_token_reused event, got %v", *events)
+	}
+
+	if _, err := auth.GetSession(user.Id, sessionId); !errors.Is(err, auth.ErrSessionNotFound) {
+		t.Fatalf("expected the session to be revoked, got %v", err)
+	}
+
+	status, response = doJSON(t, router, http.MethodPost, "/refresh-token", mustJSON(t, map[string]string{"refresh_token": rotated["refresh_token"].(string)}))
+	if status != http.StatusUnauthorized {
+		t.Fatalf("expected the rotated token to be dead, got %d %v", status, response)
+	}
+}
@@ -0,0 +1,138 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yosikez/crudAuth/auth"
+	"github.com/yosikez/crudAuth/config"
+)
+
+// ExternalLoginController logs users in with an external openid connect
+// provider, next to the username and password login of AuthController.
+type ExternalLoginController struct {
+	authController *AuthController
+}
+
+// NewExternalLoginController reuses the session handling of authController.
+func NewExternalLoginController(authController *AuthController) *ExternalLoginController {
+	return &ExternalLoginController{
+		authController: authController,
+	}
+}
+
+func (e *ExternalLoginController) callbackURL(provider string) string {
+	return e.authController.appCfg.URL("/login/external/" + provider + "/callback")
+}
+
+func (e *ExternalLoginController) Providers(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"data": auth.ExternalProviders(),
+	})
+}
+
+// Begin redirects to the provider. The state is also put in a cookie, so the
+// callback only works in the browser that started the login.
+func (e *ExternalLoginController) Begin(c *gin.Context) {
+	scopes, err := auth.ParseScope(c.Query("scope"))
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "validation error",
+			"errors": map[string]string{
+				"scope": err.Error(),
+			},
+		})
+		return
+	}
+
+	provider := c.Param("provider")
+	authURL, state, err := auth.BeginExternalLogin(provider, e.callbackURL(provider), scopes)
+
+	if errors.Is(err, auth.ErrExternalProviderNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "failed to start login",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if errors.Is(err, auth.ErrExternalProvider) {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"message": "failed to start login",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to start login",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	secure := strings.HasPrefix(e.authController.appCfg.BaseURL, "https://")
+
+	// lax, the callback is a top level navigation coming from the provider
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(config.ExternalLoginCookie, state, int(config.ExternalLoginDuration.Seconds()), "/login/external/"+provider, "", secure, true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+func (e *ExternalLoginController) Callback(c *gin.Context) {
+	provider := c.Param("provider")
+
+	// the cookie is not needed anymore, whatever the outcome
+	c.SetCookie(config.ExternalLoginCookie, "", -1, "/login/external/"+provider, "", false, true)
+
+	if providerError := c.Query("error"); providerError != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "failed to login",
+			"error":   providerError + " " + c.Query("error_description"),
+		})
+		return
+	}
+
+	state := c.Query("state")
+	cookie, _ := c.Cookie(config.ExternalLoginCookie)
+
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookie)) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "failed to login",
+			"error":   auth.ErrExternalLoginState.Error(),
+		})
+		return
+	}
+
+	user, scopes, err := auth.CompleteExternalLogin(provider, state, c.Query("code"), e.callbackURL(provider))
+
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, auth.ErrExternalProviderNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, auth.ErrExternalLoginState):
+			status = http.StatusBadRequest
+		case errors.Is(err, auth.ErrExternalIdToken):
+			status = http.StatusUnauthorized
+		case errors.Is(err, auth.ErrExternalProvider):
+			status = http.StatusBadGateway
+		case errors.Is(err, auth.ErrExternalEmailNotVerified), errors.Is(err, auth.ErrExternalAccountNotLinked),
+			errors.Is(err, auth.ErrUserDisabled), errors.Is(err, auth.ErrPasswordResetRequired):
+			status = http.StatusForbidden
+		}
+
+		c.JSON(status, gin.H{
+			"message": "failed to login",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	e.authController.finishFederatedLogin(c, user, scopes)
+}
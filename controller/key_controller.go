@@ -0,0 +1,31 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yosikez/crudAuth/auth"
+)
+
+type KeyController struct{}
+
+func NewKeyController() *KeyController {
+	return &KeyController{}
+}
+
+func (k *KeyController) JWKS(c *gin.Context) {
+	jwks, err := auth.JWKS()
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to build jwks",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.JSON(http.StatusOK, gin.H{
+		"keys": jwks,
+	})
+}
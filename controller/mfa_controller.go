@@ -0,0 +1,173 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yosikez/crudAuth/auth"
+	"github.com/yosikez/crudAuth/input"
+	"github.com/yosikez/crudAuth/model"
+
+	cusMessage "github.com/yosikez/custom-error-message"
+)
+
+type MfaController struct{}
+
+func NewMfaController() *MfaController {
+	return &MfaController{}
+}
+
+func (m *MfaController) EnrollTotp(c *gin.Context) {
+	user, ok := m.currentUser(c)
+	if !ok {
+		return
+	}
+
+	secret, uri, err := auth.EnrollTotp(user)
+
+	if err != nil {
+		if errors.Is(err, auth.ErrTotpAlreadyEnabled) {
+			c.JSON(http.StatusConflict, gin.H{
+				"message": "failed to enroll totp",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to enroll totp",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"secret":      secret,
+			"otpauth_uri": uri,
+		},
+	})
+}
+
+func (m *MfaController) ConfirmTotp(c *gin.Context) {
+	var body input.MfaCodeInput
+
+	if !m.bind(c, &body) {
+		return
+	}
+
+	user, ok := m.currentUser(c)
+	if !ok {
+		return
+	}
+
+	codes, err := auth.ConfirmTotp(user, body.Code)
+
+	if err != nil {
+		m.codeError(c, "failed to confirm totp", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "totp enabled, store the recovery codes somewhere safe, they are only shown once",
+		"data": gin.H{
+			"recovery_codes": codes,
+		},
+	})
+}
+
+func (m *MfaController) DisableTotp(c *gin.Context) {
+	var body input.MfaCodeInput
+
+	if !m.bind(c, &body) {
+		return
+	}
+
+	user, ok := m.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if err := auth.DisableTotp(user, body.Code); err != nil {
+		m.codeError(c, "failed to disable totp", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "totp disabled",
+	})
+}
+
+func (m *MfaController) RegenerateRecoveryCodes(c *gin.Context) {
+	var body input.MfaCodeInput
+
+	if !m.bind(c, &body) {
+		return
+	}
+
+	user, ok := m.currentUser(c)
+	if !ok {
+		return
+	}
+
+	codes, err := auth.RegenerateRecoveryCodes(user, body.Code)
+
+	if err != nil {
+		m.codeError(c, "failed to regenerate recovery codes", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"recovery_codes": codes,
+		},
+	})
+}
+
+func (m *MfaController) bind(c *gin.Context, body *input.MfaCodeInput) bool {
+	if err := c.ShouldBindJSON(body); err != nil {
+		errFields := cusMessage.GetErrMess(err, *body, nil)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "validation error",
+			"errors":  errFields,
+		})
+		return false
+	}
+
+	return true
+}
+
+func (m *MfaController) currentUser(c *gin.Context) (*model.User, bool) {
+	user, err := auth.LoadUser(c.GetUint("userId"))
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "failed to find user",
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+
+	return user, true
+}
+
+func (m *MfaController) codeError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, auth.ErrInvalidMfaCode):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": message,
+			"error":   err.Error(),
+		})
+	case errors.Is(err, auth.ErrTotpNotEnrolled), errors.Is(err, auth.ErrTotpAlreadyEnabled):
+		c.JSON(http.StatusConflict, gin.H{
+			"message": message,
+			"error":   err.Error(),
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": message,
+			"error":   err.Error(),
+		})
+	}
+}
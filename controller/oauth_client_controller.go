@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yosikez/crudAuth/auth"
+	"github.com/yosikez/crudAuth/input"
+	"github.com/yosikez/crudAuth/model"
+
+	cusMessage "github.com/yosikez/custom-error-message"
+)
+
+// OAuthClientController is the admin api for registering oauth clients.
+type OAuthClientController struct{}
+
+func NewOAuthClientController() *OAuthClientController {
+	return &OAuthClientController{}
+}
+
+func oauthClientResponse(client *model.OauthClient) gin.H {
+	return gin.H{
+		"client_id":     client.Id,
+		"name":          client.Name,
+		"redirect_uris": client.RedirectURIList(),
+		"grant_types":   client.GrantTypeList(),
+		"scopes":        client.ScopeList(),
+		"confidential":  client.IsConfidential(),
+		"created_at":    client.CreateAt,
+	}
+}
+
+func (o *OAuthClientController) FindAll(c *gin.Context) {
+	clients, err := auth.ListOAuthClients()
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to find oauth clients",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	data := make([]gin.H, 0, len(clients))
+	for i := range clients {
+		data = append(data, oauthClientResponse(&clients[i]))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": data,
+	})
+}
+
+// Create returns the client secret of confidential clients only this once.
+func (o *OAuthClientController) Create(c *gin.Context) {
+	var body input.CreateOAuthClientInput
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		errFields := cusMessage.GetErrMess(err, body, nil)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "validation error",
+			"errors":  errFields,
+		})
+		return
+	}
+
+	client, secret, err := auth.CreateOAuthClient(body.Name, body.RedirectURIs, body.GrantTypes, body.Scopes, body.Confidential)
+
+	if err != nil {
+		field := ""
+		switch {
+		case errors.Is(err, auth.ErrInvalidRedirectURI), errors.Is(err, auth.ErrRedirectURIRequired):
+			field = "redirect_uris"
+		case errors.Is(err, auth.ErrUnknownGrantType), errors.Is(err, auth.ErrPublicClientGrant):
+			field = "grant_types"
+		case errors.Is(err, auth.ErrInvalidScope), errors.Is(err, auth.ErrClientScope):
+			field = "scopes"
+		}
+
+		if field != "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "validation error",
+				"errors": map[string]string{
+					field: err.Error(),
+				},
+			})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to create oauth client",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	data := oauthClientResponse(client)
+	if secret != "" {
+		data["client_secret"] = secret
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"data": data,
+	})
+}
+
+func (o *OAuthClientController) Delete(c *gin.Context) {
+	if err := auth.DeleteOAuthClient(c.Param("id")); err != nil {
+		if errors.Is(err, auth.ErrOAuthClientNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "failed to find oauth client to delete",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to delete oauth client",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "oauth client deleted successfully",
+	})
+}
@@ -0,0 +1,709 @@
+package controller
+
+import (
+	"embed"
+	"errors"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yosikez/crudAuth/auth"
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/model"
+)
+
+//go:embed templates/oauth_authorize.html templates/device.html
+var oauthTemplates embed.FS
+
+var (
+	authorizeTemplate = template.Must(template.ParseFS(oauthTemplates, "templates/oauth_authorize.html"))
+	deviceTemplate    = template.Must(template.ParseFS(oauthTemplates, "templates/device.html"))
+)
+
+// OAuthController is the oauth 2.0 authorization server. Its endpoints answer
+// in the rfc 6749 format instead of the message/error format of the rest of
+// the api, so standard client libraries understand them.
+type OAuthController struct {
+	authController *AuthController
+}
+
+// NewOAuthController reuses the login throttling of authController for the
+// login on the consent page.
+func NewOAuthController(authController *AuthController) *OAuthController {
+	return &OAuthController{
+		authController: authController,
+	}
+}
+
+type authorizePage struct {
+	ClientName string
+	Scopes     []string
+	Request    string
+	Username   string
+	Error      string
+}
+
+func renderAuthorizePage(c *gin.Context, status int, page authorizePage) {
+	// the consent page must not be framed, or another site could trick the
+	// user into clicking allow
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Content-Security-Policy", "frame-ancestors 'none'")
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(status)
+
+	if err := authorizeTemplate.Execute(c.Writer, page); err != nil {
+		log.Printf("failed to render authorize page : %v", err)
+	}
+}
+
+type devicePage struct {
+	UserCode   string
+	ClientName string
+	Scopes     []string
+	Username   string
+	Error      string
+	Done       string
+}
+
+// renderDevicePage sends the same headers as the consent page, for the same
+// reason.
+func renderDevicePage(c *gin.Context, status int, page devicePage) {
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Content-Security-Policy", "frame-ancestors 'none'")
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(status)
+
+	if err := deviceTemplate.Execute(c.Writer, page); err != nil {
+		log.Printf("failed to render device page : %v", err)
+	}
+}
+
+func redirectWithParams(c *gin.Context, status int, redirectURI string, params map[string]string) {
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		renderAuthorizePage(c, http.StatusBadRequest, authorizePage{Error: "invalid redirect uri"})
+		return
+	}
+
+	query := target.Query()
+	for key, value := range params {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+
+	target.RawQuery = query.Encode()
+	c.Redirect(status, target.String())
+}
+
+func authorizeErrorCode(err error) string {
+	switch {
+	case errors.Is(err, auth.ErrUnsupportedResponseType):
+		return "unsupported_response_type"
+	case errors.Is(err, auth.ErrUnauthorizedClient):
+		return "unauthorized_client"
+	case errors.Is(err, auth.ErrPkceRequired):
+		return "invalid_request"
+	case errors.Is(err, auth.ErrInvalidScope):
+		return "invalid_scope"
+	default:
+		return "server_error"
+	}
+}
+
+// Authorize validates the authorization request and shows the consent page.
+func (o *OAuthController) Authorize(c *gin.Context) {
+	req := &auth.AuthorizationRequest{
+		ResponseType:        c.Query("response_type"),
+		ClientId:            c.Query("client_id"),
+		RedirectURI:         c.Query("redirect_uri"),
+		Scope:               c.Query("scope"),
+		State:               c.Query("state"),
+		CodeChallenge:       c.Query("code_challenge"),
+		CodeChallengeMethod: c.Query("code_challenge_method"),
+		Nonce:               c.Query("nonce"),
+	}
+
+	client, scopes, err := auth.ValidateAuthorizationRequest(req)
+
+	// without a known client and redirect uri there is nowhere safe to send
+	// the error to
+	if err != nil && client == nil {
+		if errors.Is(err, auth.ErrInvalidClient) || errors.Is(err, auth.ErrInvalidRedirectURI) {
+			renderAuthorizePage(c, http.StatusBadRequest, authorizePage{Error: err.Error()})
+			return
+		}
+
+		renderAuthorizePage(c, http.StatusInternalServerError, authorizePage{Error: "failed to start authorization"})
+		return
+	}
+
+	if err != nil {
+		redirectWithParams(c, http.StatusFound, req.RedirectURI, map[string]string{
+			"error":             authorizeErrorCode(err),
+			"error_description": err.Error(),
+			"state":             req.State,
+		})
+		return
+	}
+
+	request, err := auth.SignAuthorizationRequest(req, scopes)
+
+	if err != nil {
+		renderAuthorizePage(c, http.StatusInternalServerError, authorizePage{Error: "failed to start authorization"})
+		return
+	}
+
+	renderAuthorizePage(c, http.StatusOK, authorizePage{
+		ClientName: client.Name,
+		Scopes:     scopes,
+		Request:    request,
+	})
+}
+
+// formLogin checks the username, password and two-factor code posted with a
+// consent form, with the same throttling as the api login. When it fails the
+// user is nil and the status and message say what to show.
+func (o *OAuthController) formLogin(c *gin.Context) (*model.User, int, string) {
+	username := c.PostForm("username")
+
+	wait, err := auth.CheckLoginAllowed(username, c.ClientIP())
+
+	if errors.Is(err, auth.ErrAccountLocked) || errors.Is(err, auth.ErrLoginThrottled) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return nil, http.StatusTooManyRequests, err.Error()
+	}
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, "failed to login"
+	}
+
+	user, err := auth.AuthenticateUser(username, c.PostForm("password"))
+
+	if errors.Is(err, auth.ErrUserDisabled) || errors.Is(err, auth.ErrPasswordResetRequired) {
+		return nil, http.StatusForbidden, err.Error()
+	}
+
+	if errors.Is(err, auth.ErrAuthenticatorUnavailable) {
+		o.authController.forgiveLoginAttempt(c, username)
+		return nil, http.StatusServiceUnavailable, auth.ErrAuthenticatorUnavailable.Error()
+	}
+
+	if err != nil {
+		o.authController.recordLoginFailure(c, username, nil)
+		return nil, http.StatusBadRequest, "invalid credentials"
+	}
+
+	totpEnabled, err := auth.IsTotpEnabled(user.Id)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, "failed to login"
+	}
+
+	if totpEnabled {
+		if err := auth.VerifyMfaCode(user.Id, c.PostForm("code")); err != nil {
+			if errors.Is(err, auth.ErrInvalidMfaCode) {
+				o.authController.recordLoginFailure(c, username, user)
+			}
+
+			return nil, http.StatusBadRequest, "invalid two-factor code"
+		}
+	}
+
+	o.authController.resetLoginFailures(c, user)
+
+	return user, http.StatusOK, ""
+}
+
+// Approve handles the consent form: the user logs in and allows or denies
+// the request, either way the answer goes to the client's redirect uri.
+func (o *OAuthController) Approve(c *gin.Context) {
+	req, err := auth.ParseAuthorizationRequest(c.PostForm("request"))
+
+	if err != nil {
+		renderAuthorizePage(c, http.StatusBadRequest, authorizePage{Error: err.Error()})
+		return
+	}
+
+	client, err := auth.GetOAuthClient(req.ClientId)
+
+	if err != nil {
+		renderAuthorizePage(c, http.StatusBadRequest, authorizePage{Error: err.Error()})
+		return
+	}
+
+	if c.PostForm("decision") != "approve" {
+		redirectWithParams(c, http.StatusSeeOther, req.RedirectURI, map[string]string{
+			"error": "access_denied",
+			"state": req.State,
+		})
+		return
+	}
+
+	page := authorizePage{
+		ClientName: client.Name,
+		Scopes:     strings.Fields(req.Scope),
+		Request:    c.PostForm("request"),
+		Username:   c.PostForm("username"),
+	}
+
+	user, status, message := o.formLogin(c)
+
+	if user == nil {
+		page.Error = message
+		renderAuthorizePage(c, status, page)
+		return
+	}
+
+	code, err := auth.CreateAuthorizationCode(user, req)
+
+	if err != nil {
+		redirectWithParams(c, http.StatusSeeOther, req.RedirectURI, map[string]string{
+			"error": "server_error",
+			"state": req.State,
+		})
+		return
+	}
+
+	redirectWithParams(c, http.StatusSeeOther, req.RedirectURI, map[string]string{
+		"code":  code,
+		"state": req.State,
+	})
+}
+
+func oauthError(c *gin.Context, status int, code string, err error) {
+	body := gin.H{"error": code}
+	if err != nil {
+		body["error_description"] = err.Error()
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, body)
+}
+
+// authenticateClient reads the client from http basic auth or from the
+// client_id and client_secret form fields, and answers invalid_client itself
+// when that fails.
+func (o *OAuthController) authenticateClient(c *gin.Context) (*model.OauthClient, bool) {
+	id, secret, basic := c.Request.BasicAuth()
+	if basic {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id = c.PostForm("client_id")
+		secret = c.PostForm("client_secret")
+	}
+
+	client, err := auth.AuthenticateOAuthClient(id, secret)
+
+	if err != nil {
+		if basic {
+			c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+
+		if errors.Is(err, auth.ErrInvalidClient) {
+			oauthError(c, http.StatusUnauthorized, "invalid_client", err)
+			return nil, false
+		}
+
+		oauthError(c, http.StatusInternalServerError, "server_error", nil)
+		return nil, false
+	}
+
+	return client, true
+}
+
+// idToken is only issued when the openid scope was granted. A refresh has no
+// nonce and no new authentication, so it passes the zero values.
+func (o *OAuthController) idToken(user *model.User, clientId string, scopes []string, nonce string, authTime time.Time) (string, error) {
+	if !auth.HasScope(scopes, config.ScopeOpenId) {
+		return "", nil
+	}
+
+	return auth.GenerateIdToken(o.authController.appCfg.Issuer(), user, clientId, scopes, nonce, authTime)
+}
+
+func tokenResponse(c *gin.Context, accessToken, refreshToken, idToken string, scopes []string) {
+	body := gin.H{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(config.AccessTokenDuration.Seconds()),
+		"scope":        auth.FormatScope(scopes),
+	}
+
+	if refreshToken != "" {
+		body["refresh_token"] = refreshToken
+	}
+
+	if idToken != "" {
+		body["id_token"] = idToken
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	c.JSON(http.StatusOK, body)
+}
+
+// issueUserTokens starts a session of the user at the client and answers
+// with its tokens. The refresh token is only handed out when the client may
+// use it.
+func (o *OAuthController) issueUserTokens(c *gin.Context, client *model.OauthClient, user *model.User, scopes []string, nonce string, authTime time.Time) {
+	sessionId, err := auth.NewSessionId()
+
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", nil)
+		return
+	}
+
+	accessToken, refreshToken, err := auth.GenerateTokens(user, sessionId, client.Id, scopes)
+
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", nil)
+		return
+	}
+
+	if _, err := auth.CreateSession(user, sessionId, client.Id, refreshToken, c.Request.UserAgent(), c.ClientIP()); err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", nil)
+		return
+	}
+
+	idToken, err := o.idToken(user, client.Id, scopes, nonce, authTime)
+
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", nil)
+		return
+	}
+
+	if !client.AllowsGrant(config.GrantRefreshToken) {
+		refreshToken = ""
+	}
+
+	tokenResponse(c, accessToken, refreshToken, idToken, scopes)
+}
+
+// deviceCodeError maps a failed poll to its rfc 8628 section 3.5 error code.
+func deviceCodeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, auth.ErrAuthorizationPending):
+		oauthError(c, http.StatusBadRequest, "authorization_pending", err)
+	case errors.Is(err, auth.ErrSlowDown):
+		oauthError(c, http.StatusBadRequest, "slow_down", err)
+	case errors.Is(err, auth.ErrAccessDenied):
+		oauthError(c, http.StatusBadRequest, "access_denied", err)
+	case errors.Is(err, auth.ErrExpiredToken):
+		oauthError(c, http.StatusBadRequest, "expired_token", err)
+	case errors.Is(err, auth.ErrUnauthorizedClient):
+		oauthError(c, http.StatusBadRequest, "unauthorized_client", err)
+	case errors.Is(err, auth.ErrInvalidGrant):
+		oauthError(c, http.StatusBadRequest, "invalid_grant", err)
+	default:
+		oauthError(c, http.StatusInternalServerError, "server_error", nil)
+	}
+}
+
+func (o *OAuthController) Token(c *gin.Context) {
+	client, ok := o.authenticateClient(c)
+	if !ok {
+		return
+	}
+
+	switch grantType := c.PostForm("grant_type"); grantType {
+	case config.GrantAuthorizationCode:
+		user, code, err := auth.ExchangeAuthorizationCode(client, c.PostForm("code"), c.PostForm("redirect_uri"), c.PostForm("code_verifier"))
+
+		if errors.Is(err, auth.ErrUnauthorizedClient) {
+			oauthError(c, http.StatusBadRequest, "unauthorized_client", err)
+			return
+		}
+
+		if err != nil {
+			oauthError(c, http.StatusBadRequest, "invalid_grant", err)
+			return
+		}
+
+		o.issueUserTokens(c, client, user, strings.Fields(code.Scope), code.Nonce, code.AuthTime)
+	case config.GrantRefreshToken:
+		if !client.AllowsGrant(config.GrantRefreshToken) {
+			oauthError(c, http.StatusBadRequest, "unauthorized_client", auth.ErrUnauthorizedClient)
+			return
+		}
+
+		session, accessToken, refreshToken, err := auth.RotateRefreshToken(c.PostForm("refresh_token"), client.Id)
+
+		if errors.Is(err, auth.ErrRefreshTokenReused) {
+			o.authController.publishSecurityEvent(c, "refresh_token_reused", session)
+		}
+
+		if err != nil {
+			oauthError(c, http.StatusBadRequest, "invalid_grant", err)
+			return
+		}
+
+		claims, err := auth.ParseRefreshToken(refreshToken)
+
+		if err != nil {
+			oauthError(c, http.StatusInternalServerError, "server_error", nil)
+			return
+		}
+
+		scopes := strings.Fields(claims.Scope)
+		idToken := ""
+
+		if auth.HasScope(scopes, config.ScopeOpenId) {
+			user, err := auth.LoadUser(claims.Id)
+
+			if err != nil {
+				oauthError(c, http.StatusBadRequest, "invalid_grant", auth.ErrInvalidGrant)
+				return
+			}
+
+			idToken, err = o.idToken(user, client.Id, scopes, "", time.Time{})
+
+			if err != nil {
+				oauthError(c, http.StatusInternalServerError, "server_error", nil)
+				return
+			}
+		}
+
+		tokenResponse(c, accessToken, refreshToken, idToken, scopes)
+	case config.GrantClientCredentials:
+		accessToken, scopes, err := auth.IssueClientCredentialsToken(client, c.PostForm("scope"))
+
+		if errors.Is(err, auth.ErrInvalidScope) {
+			oauthError(c, http.StatusBadRequest, "invalid_scope", err)
+			return
+		}
+
+		if errors.Is(err, auth.ErrUnauthorizedClient) {
+			oauthError(c, http.StatusBadRequest, "unauthorized_client", err)
+			return
+		}
+
+		if err != nil {
+			oauthError(c, http.StatusInternalServerError, "server_error", nil)
+			return
+		}
+
+		tokenResponse(c, accessToken, "", "", scopes)
+	case config.GrantDeviceCode:
+		deviceCode := c.PostForm("device_code")
+
+		if deviceCode == "" {
+			oauthError(c, http.StatusBadRequest, "invalid_request", errors.New("device_code is required"))
+			return
+		}
+
+		user, row, err := auth.PollDeviceCode(client, deviceCode)
+
+		if err != nil {
+			deviceCodeError(c, err)
+			return
+		}
+
+		o.issueUserTokens(c, client, user, strings.Fields(row.Scope), "", *row.AuthTime)
+	default:
+		oauthError(c, http.StatusBadRequest, "unsupported_grant_type", errors.New("unsupported grant_type "+grantType))
+	}
+}
+
+// Revoke answers 200 for unknown tokens as well, rfc 7009 section 2.2.
+func (o *OAuthController) Revoke(c *gin.Context) {
+	client, ok := o.authenticateClient(c)
+	if !ok {
+		return
+	}
+
+	token := c.PostForm("token")
+
+	if token == "" {
+		oauthError(c, http.StatusBadRequest, "invalid_request", errors.New("token is required"))
+		return
+	}
+
+	if err := auth.RevokeOAuthToken(client, token); err != nil {
+		oauthError(c, http.StatusServiceUnavailable, "temporarily_unavailable", nil)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// Introspect tells a resource server whether a token is active, rfc 7662.
+// An invalid token is not an error, the answer is active false.
+func (o *OAuthController) Introspect(c *gin.Context) {
+	client, ok := o.authenticateClient(c)
+	if !ok {
+		return
+	}
+
+	token := c.PostForm("token")
+
+	if token == "" {
+		oauthError(c, http.StatusBadRequest, "invalid_request", errors.New("token is required"))
+		return
+	}
+
+	introspection, err := auth.IntrospectToken(client, token)
+
+	if errors.Is(err, auth.ErrUnauthorizedClient) {
+		oauthError(c, http.StatusBadRequest, "unauthorized_client", err)
+		return
+	}
+
+	if err != nil {
+		oauthError(c, http.StatusServiceUnavailable, "temporarily_unavailable", nil)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, introspection)
+}
+
+// DeviceAuthorization starts the device authorization grant, rfc 8628
+// section 3.1. The device shows the user code and polls the token endpoint
+// with the device code.
+func (o *OAuthController) DeviceAuthorization(c *gin.Context) {
+	client, ok := o.authenticateClient(c)
+	if !ok {
+		return
+	}
+
+	deviceCode, row, err := auth.CreateDeviceCode(client, c.PostForm("scope"))
+
+	if errors.Is(err, auth.ErrUnauthorizedClient) {
+		oauthError(c, http.StatusBadRequest, "unauthorized_client", err)
+		return
+	}
+
+	if errors.Is(err, auth.ErrInvalidScope) {
+		oauthError(c, http.StatusBadRequest, "invalid_scope", err)
+		return
+	}
+
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", nil)
+		return
+	}
+
+	userCode := auth.FormatUserCode(row.UserCode)
+	verificationURI := o.authController.appCfg.URL("/device")
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"device_code":               deviceCode,
+		"user_code":                 userCode,
+		"verification_uri":          verificationURI,
+		"verification_uri_complete": verificationURI + "?user_code=" + url.QueryEscape(userCode),
+		"expires_in":                int(config.DeviceCodeDuration.Seconds()),
+		"interval":                  row.PollInterval,
+	})
+}
+
+// lookupDeviceCode finds the code typed on the verification page, throttled
+// per ip so codes can not be guessed. When it fails the error page is
+// already rendered.
+func lookupDeviceCode(c *gin.Context, userCode string) (*model.OauthDeviceCode, *model.OauthClient, bool) {
+	wait, err := auth.CheckUserCodeLookupAllowed(c.ClientIP())
+
+	if errors.Is(err, auth.ErrUserCodeThrottled) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		renderDevicePage(c, http.StatusTooManyRequests, devicePage{UserCode: userCode, Error: err.Error()})
+		return nil, nil, false
+	}
+
+	if err != nil {
+		renderDevicePage(c, http.StatusInternalServerError, devicePage{UserCode: userCode, Error: "failed to find the code"})
+		return nil, nil, false
+	}
+
+	row, client, err := auth.LookupDeviceCode(userCode)
+
+	if errors.Is(err, auth.ErrInvalidUserCode) {
+		renderDevicePage(c, http.StatusNotFound, devicePage{UserCode: userCode, Error: err.Error()})
+		return nil, nil, false
+	}
+
+	if err != nil {
+		renderDevicePage(c, http.StatusInternalServerError, devicePage{UserCode: userCode, Error: "failed to find the code"})
+		return nil, nil, false
+	}
+
+	if err := auth.ForgiveUserCodeLookup(c.ClientIP()); err != nil {
+		log.Printf("failed to forgive user code lookup for %s : %v", c.ClientIP(), err)
+	}
+
+	return row, client, true
+}
+
+// Device is the verification page. Without a code it asks for one, with a
+// code it shows what the device asks for.
+func (o *OAuthController) Device(c *gin.Context) {
+	userCode := c.Query("user_code")
+
+	if userCode == "" {
+		renderDevicePage(c, http.StatusOK, devicePage{})
+		return
+	}
+
+	row, client, ok := lookupDeviceCode(c, userCode)
+
+	if !ok {
+		return
+	}
+
+	renderDevicePage(c, http.StatusOK, devicePage{
+		UserCode:   auth.FormatUserCode(row.UserCode),
+		ClientName: client.Name,
+		Scopes:     strings.Fields(row.Scope),
+	})
+}
+
+// ApproveDevice handles the form of the verification page: the user logs in
+// and allows the device, or denies it.
+func (o *OAuthController) ApproveDevice(c *gin.Context) {
+	row, client, ok := lookupDeviceCode(c, c.PostForm("user_code"))
+
+	if !ok {
+		return
+	}
+
+	if c.PostForm("decision") != "approve" {
+		if err := auth.DecideDeviceCode(row.UserCode, nil, false); err != nil {
+			renderDevicePage(c, http.StatusNotFound, devicePage{Error: err.Error()})
+			return
+		}
+
+		renderDevicePage(c, http.StatusOK, devicePage{Done: "The device was denied access."})
+		return
+	}
+
+	page := devicePage{
+		UserCode:   auth.FormatUserCode(row.UserCode),
+		ClientName: client.Name,
+		Scopes:     strings.Fields(row.Scope),
+		Username:   c.PostForm("username"),
+	}
+
+	user, status, message := o.formLogin(c)
+
+	if user == nil {
+		page.Error = message
+		renderDevicePage(c, status, page)
+		return
+	}
+
+	if err := auth.DecideDeviceCode(row.UserCode, user, true); err != nil {
+		renderDevicePage(c, http.StatusNotFound, devicePage{Error: err.Error()})
+		return
+	}
+
+	renderDevicePage(c, http.StatusOK, devicePage{Done: "The device is connected, you can go back to it now."})
+}
@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yosikez/crudAuth/auth"
+	"github.com/yosikez/crudAuth/config"
+)
+
+// OidcController is the openid connect layer on top of the oauth server:
+// discovery and userinfo, the id tokens come from the token endpoint.
+type OidcController struct {
+	appCfg *config.App
+}
+
+func NewOidcController(appCfg *config.App) *OidcController {
+	return &OidcController{
+		appCfg: appCfg,
+	}
+}
+
+func (o *OidcController) Discovery(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":                                o.appCfg.Issuer(),
+		"authorization_endpoint":                o.appCfg.URL("/oauth/authorize"),
+		"token_endpoint":                        o.appCfg.URL("/oauth/token"),
+		"revocation_endpoint":                   o.appCfg.URL("/oauth/revoke"),
+		"introspection_endpoint":                o.appCfg.URL("/introspect"),
+		"device_authorization_endpoint":         o.appCfg.URL("/device/code"),
+		"userinfo_endpoint":                     o.appCfg.URL("/userinfo"),
+		"jwks_uri":                              o.appCfg.URL("/.well-known/jwks.json"),
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{config.GrantAuthorizationCode, config.GrantRefreshToken, config.GrantClientCredentials, config.GrantDeviceCode},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": auth.SigningAlgorithms(),
+		"scopes_supported":                      config.OAuthClientScopes,
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{config.PkceMethodS256},
+		"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "email", "email_verified", "preferred_username"},
+	})
+}
+
+// UserInfo answers with the claims the access token's scopes allow.
+func (o *OidcController) UserInfo(c *gin.Context) {
+	user, err := auth.LoadUser(c.GetUint("userId"))
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "failed to find user",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, auth.UserInfo(user, c.GetStringSlice("scopes")))
+}
@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yosikez/crudAuth/auth"
+)
+
+type PasskeyController struct{}
+
+func NewPasskeyController() *PasskeyController {
+	return &PasskeyController{}
+}
+
+func (p *PasskeyController) BeginRegistration(c *gin.Context) {
+	user, err := auth.LoadUser(c.GetUint("userId"))
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "failed to find user",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	options, sessionId, err := auth.BeginPasskeyRegistration(user)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to begin passkey registration",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"session_id": sessionId,
+		"options":    options,
+	})
+}
+
+// FinishRegistration takes the authenticator response as the body, the
+// session_id from the begin step and an optional name come in the query.
+func (p *PasskeyController) FinishRegistration(c *gin.Context) {
+	user, err := auth.LoadUser(c.GetUint("userId"))
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "failed to find user",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	passkey, err := auth.FinishPasskeyRegistration(user, c.Query("session_id"), c.Query("name"), c.Request)
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "failed to register passkey",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": passkey,
+	})
+}
+
+func (p *PasskeyController) FindAll(c *gin.Context) {
+	passkeys, err := auth.ListPasskeys(c.GetUint("userId"))
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to find passkeys",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": passkeys,
+	})
+}
+
+func (p *PasskeyController) Delete(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "invalid passkey id",
+			"error":   "id must be a number",
+		})
+		return
+	}
+
+	if err := auth.DeletePasskey(c.GetUint("userId"), uint(id)); err != nil {
+		if errors.Is(err, auth.ErrPasskeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "failed to find passkey to delete",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to delete passkey",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "passkey deleted successfully",
+	})
+}
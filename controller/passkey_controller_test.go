@@ -0,0 +1,363 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/yosikez/crudAuth/auth"
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost:8000"
+)
+
+var b64 = base64.RawURLEncoding
+
+// softAuthenticator is a passkey in memory. It answers the ceremonies the
+// way a platform authenticator does, with none attestation and user
+// verification.
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialId []byte
+	userHandle   []byte
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credentialId := make([]byte, 16)
+	if _, err := rand.Read(credentialId); err != nil {
+		t.Fatal(err)
+	}
+
+	return &softAuthenticator{key: key, credentialId: credentialId}
+}
+
+func clientData(t *testing.T, ceremony, challenge string) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    testOrigin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// authenticatorData is the rp id hash, the flags (user present and verified,
+// plus attested credential data when attested is given) and the counter.
+func (a *softAuthenticator) authenticatorData(attested []byte) []byte {
+	rpIdHash := sha256.Sum256([]byte(testRPID))
+	flags := byte(0x01 | 0x04)
+	if attested != nil {
+		flags |= 0x40
+	}
+
+	data := append(rpIdHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+
+	return append(data, attested...)
+}
+
+// register answers the creation options and returns the body for the finish
+// step.
+func (a *softAuthenticator) register(t *testing.T, options map[string]interface{}) []byte {
+	t.Helper()
+
+	publicKey := options["publicKey"].(map[string]interface{})
+	user := publicKey["user"].(map[string]interface{})
+
+	handle, err := b64.DecodeString(user["id"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.userHandle = handle
+
+	coseKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1,
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attested := make([]byte, 16) // zero aaguid
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialId)))
+	attested = append(attested, a.credentialId...)
+	attested = append(attested, coseKey...)
+
+	attestationObject, err := webauthncbor.Marshal(struct {
+		Fmt      string                 `cbor:"fmt"`
+		AttStmt  map[string]interface{} `cbor:"attStmt"`
+		AuthData []byte                 `cbor:"authData"`
+	}{"none", map[string]interface{}{}, a.authenticatorData(attested)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mustJSON(t, map[string]interface{}{
+		"id":    b64.EncodeToString(a.credentialId),
+		"rawId": b64.EncodeToString(a.credentialId),
+		"type":  "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON":    b64.EncodeToString(clientData(t, "webauthn.create", publicKey["challenge"].(string))),
+			"attestationObject": b64.EncodeToString(attestationObject),
+			"transports":        []string{"internal"},
+		},
+	})
+}
+
+// login answers the request options of a discoverable login and returns the
+// body for the finish step.
+func (a *softAuthenticator) login(t *testing.T, options map[string]interface{}) []byte {
+	t.Helper()
+
+	challenge := options["publicKey"].(map[string]interface{})["challenge"].(string)
+
+	a.signCount++
+	authData := a.authenticatorData(nil)
+	data := clientData(t, "webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(data)
+
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mustJSON(t, map[string]interface{}{
+		"id":    b64.EncodeToString(a.credentialId),
+		"rawId": b64.EncodeToString(a.credentialId),
+		"type":  "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON":    b64.EncodeToString(data),
+			"authenticatorData": b64.EncodeToString(authData),
+			"signature":         b64.EncodeToString(signature),
+			"userHandle":        b64.EncodeToString(a.userHandle),
+		},
+	})
+}
+
+func setupPasskeys(t *testing.T) {
+	t.Helper()
+
+	setupAuth(t)
+
+	if err := auth.InitWebAuthn(&config.WebAuthn{RPID: testRPID, RPDisplayName: "crudAuth", RPOrigins: []string{testOrigin}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// passkeyRouter mounts the passkey routes like router/api.go, with userId set
+// the way the AuthMiddleware would.
+func passkeyRouter(userId uint) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	authController := &AuthController{}
+	passkeyController := NewPasskeyController()
+
+	router.POST("/login/passkey/begin", authController.BeginPasskeyLogin)
+	router.POST("/login/passkey/finish", authController.FinishPasskeyLogin)
+
+	account := router.Group("/api/me", func(c *gin.Context) {
+		c.Set("userId", userId)
+	})
+	account.GET("/passkeys", passkeyController.FindAll)
+	account.POST("/passkeys/register/begin", passkeyController.BeginRegistration)
+	account.POST("/passkeys/register/finish", passkeyController.FinishRegistration)
+
+	return router
+}
+
+// registerPasskey runs both registration steps and fails the test unless the
+// passkey was stored.
+func registerPasskey(t *testing.T, router *gin.Engine, authenticator *softAuthenticator) {
+	t.Helper()
+
+	status, begin := doJSON(t, router, http.MethodPost, "/api/me/passkeys/register/begin", nil)
+	if status != http.StatusOK {
+		t.Fatalf("begin registration answered %d: %v", status, begin)
+	}
+
+	target := "/api/me/passkeys/register/finish?name=laptop&session_id=" + url.QueryEscape(begin["session_id"].(string))
+	status, finish := doJSON(t, router, http.MethodPost, target, authenticator.register(t, begin["options"].(map[string]interface{})))
+	if status != http.StatusOK {
+		t.Fatalf("finish registration answered %d: %v", status, finish)
+	}
+}
+
+func passkeyLogin(t *testing.T, router *gin.Engine, authenticator *softAuthenticator) (int, map[string]interface{}) {
+	t.Helper()
+
+	status, begin := doJSON(t, router, http.MethodPost, "/login/passkey/begin", nil)
+	if status != http.StatusOK {
+		t.Fatalf("begin login answered %d: %v", status, begin)
+	}
+
+	target := "/login/passkey/finish?session_id=" + url.QueryEscape(begin["session_id"].(string))
+
+	return doJSON(t, router, http.MethodPost, target, authenticator.login(t, begin["options"].(map[string]interface{})))
+}
+
+func TestPasskeyRegistrationAndDiscoverableLogin(t *testing.T) {
+	setupPasskeys(t)
+
+	user := createTestUser(t, "alice")
+	router := passkeyRouter(user.Id)
+	authenticator := newSoftAuthenticator(t)
+
+	registerPasskey(t, router, authenticator)
+
+	passkeys, err := auth.ListPasskeys(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(passkeys) != 1 || passkeys[0].Name != "laptop" || !bytes.Equal(passkeys[0].CredentialId, authenticator.credentialId) {
+		t.Fatalf("unexpected passkeys after registration: %+v", passkeys)
+	}
+
+	status, response := passkeyLogin(t, router, authenticator)
+	if status != http.StatusOK {
+		t.Fatalf("passkey login answered %d: %v", status, response)
+	}
+
+	if response["access_token"] == "" || response["refresh_token"] == "" {
+		t.Fatalf("passkey login returned no tokens: %v", response)
+	}
+
+	passkeys, err = auth.ListPasskeys(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if passkeys[0].SignCount != authenticator.signCount || passkeys[0].LastUsedAt == nil {
+		t.Fatalf("sign count and last use were not recorded: %+v", passkeys[0])
+	}
+}
+
+func TestPasskeyRegistrationRejectsWrongChallenge(t *testing.T) {
+	setupPasskeys(t)
+
+	user := createTestUser(t, "alice")
+	router := passkeyRouter(user.Id)
+	authenticator := newSoftAuthenticator(t)
+
+	status, begin := doJSON(t, router, http.MethodPost, "/api/me/passkeys/register/begin", nil)
+	if status != http.StatusOK {
+		t.Fatalf("begin registration answered %d: %v", status, begin)
+	}
+
+	options := begin["options"].(map[string]interface{})
+	options["publicKey"].(map[string]interface{})["challenge"] = b64.EncodeToString([]byte("not the challenge we were sent"))
+
+	target := "/api/me/passkeys/register/finish?session_id=" + url.QueryEscape(begin["session_id"].(string))
+	if status, response := doJSON(t, router, http.MethodPost, target, authenticator.register(t, options)); status != http.StatusBadRequest {
+		t.Fatalf("registration with a wrong challenge answered %d: %v", status, response)
+	}
+
+	passkeys, err := auth.ListPasskeys(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(passkeys) != 0 {
+		t.Fatalf("passkey stored despite the wrong challenge: %+v", passkeys)
+	}
+}
+
+func TestPasskeyLoginSessionIsSingleUse(t *testing.T) {
+	setupPasskeys(t)
+
+	user := createTestUser(t, "alice")
+	router := passkeyRouter(user.Id)
+	authenticator := newSoftAuthenticator(t)
+
+	registerPasskey(t, router, authenticator)
+
+	status, begin := doJSON(t, router, http.MethodPost, "/login/passkey/begin", nil)
+	if status != http.StatusOK {
+		t.Fatalf("begin login answered %d: %v", status, begin)
+	}
+
+	target := "/login/passkey/finish?session_id=" + url.QueryEscape(begin["session_id"].(string))
+	body := authenticator.login(t, begin["options"].(map[string]interface{}))
+
+	if status, response := doJSON(t, router, http.MethodPost, target, body); status != http.StatusOK {
+		t.Fatalf("passkey login answered %d: %v", status, response)
+	}
+
+	if status, response := doJSON(t, router, http.MethodPost, target, body); status != http.StatusUnauthorized {
+		t.Fatalf("replayed passkey login answered %d: %v", status, response)
+	}
+}
+
+func TestPasskeyLoginRejectsClonedAuthenticator(t *testing.T) {
+	setupPasskeys(t)
+
+	user := createTestUser(t, "alice")
+	router := passkeyRouter(user.Id)
+	authenticator := newSoftAuthenticator(t)
+
+	registerPasskey(t, router, authenticator)
+
+	authenticator.signCount = 5
+	if status, response := passkeyLogin(t, router, authenticator); status != http.StatusOK {
+		t.Fatalf("passkey login answered %d: %v", status, response)
+	}
+
+	// a copy of the key that fell behind the original
+	authenticator.signCount = 2
+	if status, response := passkeyLogin(t, router, authenticator); status != http.StatusUnauthorized {
+		t.Fatalf("login with a counter that went backwards answered %d: %v", status, response)
+	}
+}
+
+func TestPasskeyLoginRefusesDisabledUser(t *testing.T) {
+	setupPasskeys(t)
+
+	user := createTestUser(t, "alice")
+	router := passkeyRouter(user.Id)
+	authenticator := newSoftAuthenticator(t)
+
+	registerPasskey(t, router, authenticator)
+
+	if err := database.DB.Model(user).Update("is_disabled", true).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if status, response := passkeyLogin(t, router, authenticator); status != http.StatusForbidden {
+		t.Fatalf("passkey login of a disabled user answered %d: %v", status, response)
+	}
+}
@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yosikez/crudAuth/auth"
+	"github.com/yosikez/crudAuth/input"
+	"github.com/yosikez/crudAuth/model"
+
+	cusMessage "github.com/yosikez/custom-error-message"
+)
+
+type PersonalAccessTokenController struct{}
+
+func NewPersonalAccessTokenController() *PersonalAccessTokenController {
+	return &PersonalAccessTokenController{}
+}
+
+func personalAccessTokenResponse(pat *model.PersonalAccessToken) gin.H {
+	return gin.H{
+		"id":           pat.Id,
+		"name":         pat.Name,
+		"scopes":       pat.ScopeNames(),
+		"expires_at":   pat.ExpiresAt,
+		"last_used_at": pat.LastUsedAt,
+		"created_at":   pat.CreateAt,
+	}
+}
+
+func (p *PersonalAccessTokenController) FindAll(c *gin.Context) {
+	tokens, err := auth.ListPersonalAccessTokens(c.GetUint("userId"))
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to find personal access tokens",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	data := make([]gin.H, 0, len(tokens))
+	for i := range tokens {
+		data = append(data, personalAccessTokenResponse(&tokens[i]))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": data,
+	})
+}
+
+// Create returns the token in the response only this once.
+func (p *PersonalAccessTokenController) Create(c *gin.Context) {
+	var body input.CreatePersonalAccessTokenInput
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		errFields := cusMessage.GetErrMess(err, body, nil)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "validation error",
+			"errors":  errFields,
+		})
+		return
+	}
+
+	user, err := auth.LoadUser(c.GetUint("userId"))
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "failed to find user",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	var expiresAt *time.Time
+	if body.ExpiresInDays > 0 {
+		at := time.Now().AddDate(0, 0, body.ExpiresInDays)
+		expiresAt = &at
+	}
+
+	token, pat, err := auth.CreatePersonalAccessToken(user, body.Name, body.Scopes, expiresAt)
+
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidScope) || errors.Is(err, auth.ErrScopeNotAllowed) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "validation error",
+				"errors": map[string]string{
+					"scopes": err.Error(),
+				},
+			})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to create personal access token",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	data := personalAccessTokenResponse(pat)
+	data["token"] = token
+
+	c.JSON(http.StatusCreated, gin.H{
+		"data": data,
+	})
+}
+
+func (p *PersonalAccessTokenController) Delete(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "invalid personal access token id",
+			"error":   "id must be a number",
+		})
+		return
+	}
+
+	if err := auth.DeletePersonalAccessToken(c.GetUint("userId"), uint(id)); err != nil {
+		if errors.Is(err, auth.ErrPersonalAccessTokenNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "failed to find personal access token to delete",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to delete personal access token",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "personal access token revoked successfully",
+	})
+}
@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yosikez/crudAuth/auth"
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/input"
+
+	cusMessage "github.com/yosikez/custom-error-message"
+)
+
+type ProfileController struct {
+	rmq    *config.RabbitMQConnection
+	rmqCfg *config.RabbitMQ
+	appCfg *config.App
+}
+
+func NewProfileController(rqConnection *config.RabbitMQConnection, rqConfig *config.RabbitMQ, appConfig *config.App) *ProfileController {
+	return &ProfileController{
+		rmq:    rqConnection,
+		rmqCfg: rqConfig,
+		appCfg: appConfig,
+	}
+}
+
+func (p *ProfileController) Show(c *gin.Context) {
+	user, err := auth.LoadUser(c.GetUint("userId"))
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "failed to find user",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	response := gin.H{
+		"data": userResponse(user),
+	}
+
+	// support acting as the user sees the same profile, flagged with who
+	// they are
+	if actor, ok := c.Get("actor"); ok {
+		response["impersonated_by"] = actor
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (p *ProfileController) Update(c *gin.Context) {
+	user, err := auth.LoadUser(c.GetUint("userId"))
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "failed to find user",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	body := input.UpdateProfileInput{Id: user.Id}
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		errFields := cusMessage.GetErrMess(err, body, nil)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "validation error",
+			"errors":  errFields,
+		})
+		return
+	}
+
+	updates := map[string]interface{}{}
+
+	if body.Username != "" && body.Username != user.Username {
+		updates["username"] = body.Username
+	}
+
+	emailChanged := body.Email != "" && body.Email != user.Email
+	if emailChanged {
+		updates["email"] = body.Email
+		updates["email_verified"] = false
+	}
+
+	if len(updates) > 0 {
+		if err := database.DB.Model(user).Updates(updates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "failed to update profile",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+
+	if emailChanged {
+		if err := sendVerification(p.rmq, p.rmqCfg, p.appCfg, user); err != nil {
+			log.Printf("failed to send verification email to user %d : %v", user.Id, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": userResponse(user),
+	})
+}
+
+func (p *ProfileController) ChangePassword(c *gin.Context) {
+	var body input.ChangePasswordInput
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		errFields := cusMessage.GetErrMess(err, body, nil)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "validation error",
+			"errors":  errFields,
+		})
+		return
+	}
+
+	user, err := auth.LoadUser(c.GetUint("userId"))
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "failed to find user",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err := auth.ChangePassword(user, body.CurrentPassword, body.NewPassword, c.GetString("sessionId")); err != nil {
+		if errors.Is(err, auth.ErrInvalidPassword) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "failed to change password",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		if errors.Is(err, auth.ErrPasswordManagedExternally) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"message": "failed to change password",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		passwordPolicyError(c, "new_password", "failed to change password", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "password changed successfully, other sessions have been logged out",
+	})
+}
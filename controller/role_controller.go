@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yosikez/crudAuth/auth"
+	"github.com/yosikez/crudAuth/input"
+
+	cusMessage "github.com/yosikez/custom-error-message"
+)
+
+type RoleController struct{}
+
+func NewRoleController() *RoleController {
+	return &RoleController{}
+}
+
+func (r *RoleController) FindAll(c *gin.Context) {
+	roles, err := auth.ListRoles()
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to find roles",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": roles,
+	})
+}
+
+func (r *RoleController) AssignToUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "invalid user id",
+			"error":   "id must be a number",
+		})
+		return
+	}
+
+	var body input.AssignRolesInput
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		errFields := cusMessage.GetErrMess(err, body, nil)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "validation error",
+			"errors":  errFields,
+		})
+		return
+	}
+
+	user, err := auth.LoadUser(uint(id))
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "failed to find user",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err := auth.AssignRoles(user, body.Roles); err != nil {
+		if errors.Is(err, auth.ErrUnknownRole) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "failed to assign roles",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		if errors.Is(err, auth.ErrLastAdmin) {
+			c.JSON(http.StatusConflict, gin.H{
+				"message": "failed to assign roles",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to assign roles",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"user_id": user.Id,
+			"roles":   user.RoleNames(),
+		},
+	})
+}
@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yosikez/crudAuth/auth"
+)
+
+// SamlController is the saml 2.0 service provider for single sign-on with
+// an enterprise identity provider.
+type SamlController struct {
+	authController *AuthController
+}
+
+// NewSamlController reuses the session handling of authController.
+func NewSamlController(authController *AuthController) *SamlController {
+	return &SamlController{
+		authController: authController,
+	}
+}
+
+func (s *SamlController) Metadata(c *gin.Context) {
+	metadata, err := auth.SamlMetadata()
+
+	if errors.Is(err, auth.ErrSamlNotConfigured) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "failed to build saml metadata",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to build saml metadata",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/samlmetadata+xml", metadata)
+}
+
+// Login redirects to the identity provider with a signed AuthnRequest.
+func (s *SamlController) Login(c *gin.Context) {
+	scopes, err := auth.ParseScope(c.Query("scope"))
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "validation error",
+			"errors": map[string]string{
+				"scope": err.Error(),
+			},
+		})
+		return
+	}
+
+	redirectURL, err := auth.BeginSamlLogin(scopes)
+
+	if errors.Is(err, auth.ErrSamlNotConfigured) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "failed to start login",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to start login",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.Redirect(http.StatusFound, redirectURL)
+}
+
+// Acs is the assertion consumer service the identity provider posts the
+// response to.
+func (s *SamlController) Acs(c *gin.Context) {
+	user, scopes, err := auth.CompleteSamlLogin(c.Request)
+
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, auth.ErrSamlNotConfigured):
+			status = http.StatusNotFound
+		case errors.Is(err, auth.ErrSamlRequest):
+			status = http.StatusBadRequest
+		case errors.Is(err, auth.ErrInvalidSamlAssertion):
+			status = http.StatusUnauthorized
+		case errors.Is(err, auth.ErrExternalEmailNotVerified), errors.Is(err, auth.ErrExternalAccountNotLinked),
+			errors.Is(err, auth.ErrUserDisabled), errors.Is(err, auth.ErrPasswordResetRequired):
+			status = http.StatusForbidden
+		}
+
+		c.JSON(status, gin.H{
+			"message": "failed to login",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	s.authController.finishFederatedLogin(c, user, scopes)
+}
@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yosikez/crudAuth/auth"
+)
+
+type SessionController struct{}
+
+func NewSessionController() *SessionController {
+	return &SessionController{}
+}
+
+func (s *SessionController) FindAll(c *gin.Context) {
+	sessions, err := auth.ListSessions(c.GetUint("userId"))
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to find sessions",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	currentSessionId := c.GetString("sessionId")
+	data := make([]gin.H, 0, len(sessions))
+
+	for _, session := range sessions {
+		data = append(data, gin.H{
+			"id":           session.SessionId,
+			"user_agent":   session.UserAgent,
+			"ip_address":   session.IpAddress,
+			"client_id":    session.ClientId,
+			"created_at":   session.CreateAt,
+			"last_used_at": session.LastUsedAt,
+			"current":      session.SessionId == currentSessionId,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": data,
+	})
+}
+
+func (s *SessionController) Delete(c *gin.Context) {
+	if err := auth.DeleteSession(c.GetUint("userId"), c.Param("id")); err != nil {
+		if errors.Is(err, auth.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "failed to find session to delete",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to delete session",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "session revoked successfully",
+	})
+}
@@ -0,0 +1,415 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yosikez/crudAuth/auth"
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/input"
+	"github.com/yosikez/crudAuth/model"
+
+	cusMessage "github.com/yosikez/custom-error-message"
+)
+
+const (
+	defaultUsersPerPage = 20
+	maxUsersPerPage     = 100
+)
+
+// likeEscaper makes the search text match itself, a % or _ typed into the
+// search is not a wildcard
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// UserController is the admin api for managing other users' accounts.
+type UserController struct{}
+
+func NewUserController() *UserController {
+	return &UserController{}
+}
+
+func userResponse(user *model.User) gin.H {
+	return gin.H{
+		"id":                      user.Id,
+		"username":                user.Username,
+		"email":                   user.Email,
+		"roles":                   user.RoleNames(),
+		"is_disabled":             user.IsDisabled,
+		"password_reset_required": user.PasswordResetRequired,
+		"created_at":              user.CreateAt,
+		"updated_at":              user.UpdateAt,
+	}
+}
+
+func (u *UserController) FindAll(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	perPage, err := strconv.Atoi(c.DefaultQuery("per_page", strconv.Itoa(defaultUsersPerPage)))
+	if err != nil || perPage < 1 {
+		perPage = defaultUsersPerPage
+	}
+
+	if perPage > maxUsersPerPage {
+		perPage = maxUsersPerPage
+	}
+
+	query := database.DB.Model(&model.User{})
+
+	if search := c.Query("q"); search != "" {
+		pattern := "%" + likeEscaper.Replace(search) + "%"
+		query = query.Where(`LOWER(username) LIKE LOWER(?) ESCAPE '\' OR LOWER(email) LIKE LOWER(?) ESCAPE '\'`, pattern, pattern)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to count users",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	var users []model.User
+	if err := query.Preload("Roles").Order("id").Offset((page - 1) * perPage).Limit(perPage).Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to find all users",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	data := make([]gin.H, 0, len(users))
+	for i := range users {
+		data = append(data, userResponse(&users[i]))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": data,
+		"meta": gin.H{
+			"page":     page,
+			"per_page": perPage,
+			"total":    total,
+		},
+	})
+}
+
+func (u *UserController) FindById(c *gin.Context) {
+	user, ok := u.findUser(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": userResponse(user),
+	})
+}
+
+func (u *UserController) Disable(c *gin.Context) {
+	u.setDisabled(c, true)
+}
+
+func (u *UserController) Enable(c *gin.Context) {
+	u.setDisabled(c, false)
+}
+
+func (u *UserController) setDisabled(c *gin.Context, disabled bool) {
+	user, ok := u.findOtherUser(c)
+	if !ok {
+		return
+	}
+
+	if err := auth.SetUserDisabled(user, disabled); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to update user",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	user.IsDisabled = disabled
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": userResponse(user),
+	})
+}
+
+func (u *UserController) ForcePasswordReset(c *gin.Context) {
+	user, ok := u.findOtherUser(c)
+	if !ok {
+		return
+	}
+
+	if err := auth.RequirePasswordReset(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to require password reset",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	user.PasswordResetRequired = true
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": userResponse(user),
+	})
+}
+
+// Unlock lifts a lockout after too many failed logins before it runs out.
+func (u *UserController) Unlock(c *gin.Context) {
+	user, ok := u.findUser(c)
+	if !ok {
+		return
+	}
+
+	if err := auth.ResetLoginFailures(user.Username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to unlock user",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "user unlocked successfully",
+	})
+}
+
+func (u *UserController) Logout(c *gin.Context) {
+	user, ok := u.findUser(c)
+	if !ok {
+		return
+	}
+
+	if err := auth.DeleteAllSessions(user.Id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to logout user",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "user logged out from all sessions",
+	})
+}
+
+func (u *UserController) Delete(c *gin.Context) {
+	user, ok := u.findOtherUser(c)
+	if !ok {
+		return
+	}
+
+	if err := auth.DeleteUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to delete user",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "user deleted successfully",
+	})
+}
+
+// Impersonate hands an admin a token that acts as the user, for support to
+// see what the user sees. Every token is recorded in the audit log.
+func (u *UserController) Impersonate(c *gin.Context) {
+	var body input.ImpersonateInput
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		errFields := cusMessage.GetErrMess(err, body, nil)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "validation error",
+			"errors":  errFields,
+		})
+		return
+	}
+
+	user, ok := u.findOtherUser(c)
+	if !ok {
+		return
+	}
+
+	actor, err := auth.LoadUser(c.GetUint("userId"))
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "failed to find user",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	token, entry, err := auth.Impersonate(actor, user, body.Reason, c.ClientIP(), c.Request.UserAgent())
+
+	if errors.Is(err, auth.ErrImpersonateSelf) || errors.Is(err, auth.ErrUserDisabled) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "failed to impersonate user",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if errors.Is(err, auth.ErrImpersonationNotAllowed) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"message": "failed to impersonate user",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to impersonate user",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"access_token":  token,
+		"expires_at":    entry.ExpiresAt,
+		"scope":         auth.FormatScope(config.ImpersonationScopes),
+		"impersonation": entry,
+	})
+}
+
+// Impersonations is the audit log, newest first. With user_id it only shows
+// entries where that user impersonated or was impersonated.
+func (u *UserController) Impersonations(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	perPage, err := strconv.Atoi(c.DefaultQuery("per_page", strconv.Itoa(defaultUsersPerPage)))
+	if err != nil || perPage < 1 {
+		perPage = defaultUsersPerPage
+	}
+
+	if perPage > maxUsersPerPage {
+		perPage = maxUsersPerPage
+	}
+
+	query := database.DB.Model(&model.ImpersonationLog{})
+
+	if userId, err := strconv.Atoi(c.Query("user_id")); err == nil {
+		query = query.Where("actor_id = ? OR user_id = ?", userId, userId)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to count impersonations",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	var logs []model.ImpersonationLog
+	if err := query.Order("id DESC").Offset((page - 1) * perPage).Limit(perPage).Find(&logs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to find impersonations",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": logs,
+		"meta": gin.H{
+			"page":     page,
+			"per_page": perPage,
+			"total":    total,
+		},
+	})
+}
+
+// RevokeImpersonation ends an impersonation before its token expires, for a
+// token that leaked or was handed out by mistake.
+func (u *UserController) RevokeImpersonation(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "invalid impersonation id",
+			"error":   "id must be a number",
+		})
+		return
+	}
+
+	entry, err := auth.RevokeImpersonation(uint(id))
+
+	if errors.Is(err, auth.ErrImpersonationNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "failed to find impersonation to revoke",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to revoke impersonation",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "impersonation revoked successfully",
+		"impersonation": entry,
+	})
+}
+
+func (u *UserController) findUser(c *gin.Context) (*model.User, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "invalid user id",
+			"error":   "id must be a number",
+		})
+		return nil, false
+	}
+
+	user, err := auth.LoadUser(uint(id))
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "failed to find user",
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+
+	return user, true
+}
+
+// findOtherUser is findUser for actions an admin must not take on their own
+// account, so nobody can lock themselves out.
+func (u *UserController) findOtherUser(c *gin.Context) (*model.User, bool) {
+	user, ok := u.findUser(c)
+	if !ok {
+		return nil, false
+	}
+
+	if user.Id == c.GetUint("userId") {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "invalid user",
+			"error":   "cannot apply this action to your own account",
+		})
+		return nil, false
+	}
+
+	return user, true
+}
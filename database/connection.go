@@ -21,7 +21,14 @@ func Connect() error {
 
 	dsn := dbConfig.DSN()
 
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	return Open(postgres.Open(dsn))
+
+}
+
+// Open connects with dialector, then migrates and seeds. Connect uses it with
+// postgres, the tests with sqlite, see databasetest.
+func Open(dialector gorm.Dialector) error {
+	db, err := gorm.Open(dialector, &gorm.Config{})
 	if err != nil {
 		return fmt.Errorf("failed to connect to database : %v", err)
 	}
@@ -33,11 +40,14 @@ func Connect() error {
 	}
 
 	return nil
-
 }
 
 func migrate() error {
-	if err := DB.AutoMigrate(&model.User{}, &model.RefreshToken{}, &model.Todo{}); err != nil{
+	if err := DB.AutoMigrate(&model.User{}, &model.RefreshToken{}, &model.Todo{}, &model.RevokedToken{}, &model.SigningKey{}, &model.Role{}, &model.Permission{}, &model.PasswordResetToken{}, &model.TotpCredential{}, &model.RecoveryCode{}, &model.WebauthnCredential{}, &model.WebauthnSession{}, &model.LoginFailure{}, &model.PasswordHistory{}, &model.PersonalAccessToken{}, &model.OauthClient{}, &model.OauthAuthorizationCode{}, &model.OauthDeviceCode{}, &model.ExternalIdentity{}, &model.ExternalLoginState{}, &model.SamlRequest{}, &model.ImpersonationLog{}); err != nil{
+		return err
+	}
+
+	if err := seed(); err != nil {
 		return err
 	}
 
@@ -0,0 +1,30 @@
+// Package databasetest points database.DB at a fresh sqlite database with the
+// full schema and the seeded roles, so tests can run without postgres.
+package databasetest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/yosikez/crudAuth/database"
+)
+
+// Open gives the test its own database file, it is closed when the test
+// ends. The busy timeout lets a connection wait for a transaction running on
+// another one instead of failing.
+func Open(t testing.TB) {
+	t.Helper()
+
+	dsn := filepath.Join(t.TempDir(), "test.db") + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"
+
+	if err := database.Open(sqlite.Open(dsn)); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		if db, err := database.DB.DB(); err == nil {
+			db.Close()
+		}
+	})
+}
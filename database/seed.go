@@ -0,0 +1,47 @@
+package database
+
+import (
+	"github.com/yosikez/crudAuth/config"
+	"github.com/yosikez/crudAuth/model"
+)
+
+func seed() error {
+	// the default role is only handed out on the first run, later a user
+	// without roles had them taken away on purpose
+	var existingRoles int64
+	if err := DB.Model(&model.Role{}).Count(&existingRoles).Error; err != nil {
+		return err
+	}
+
+	for roleName, permissionNames := range config.DefaultRoles {
+		var role model.Role
+		if err := DB.Where(model.Role{Name: roleName}).FirstOrCreate(&role).Error; err != nil {
+			return err
+		}
+
+		permissions := make([]model.Permission, 0, len(permissionNames))
+		for _, name := range permissionNames {
+			var permission model.Permission
+			if err := DB.Where(model.Permission{Name: name}).FirstOrCreate(&permission).Error; err != nil {
+				return err
+			}
+
+			permissions = append(permissions, permission)
+		}
+
+		if err := DB.Model(&role).Association("Permissions").Append(permissions); err != nil {
+			return err
+		}
+	}
+
+	if existingRoles > 0 {
+		return nil
+	}
+
+	// users created before roles existed get the default role so they keep
+	// access to their todos
+	return DB.Exec(`INSERT INTO user_roles (user_id, role_id)
+		SELECT users.id, roles.id FROM users, roles
+		WHERE roles.name = ? AND NOT EXISTS (SELECT 1 FROM user_roles WHERE user_roles.user_id = users.id)`,
+		config.DefaultRole).Error
+}
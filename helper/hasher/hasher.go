@@ -0,0 +1,222 @@
+package hasher
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/yosikez/crudAuth/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// PasswordHasher makes and checks password hashes of one algorithm. Hashes
+// are PHC strings ($argon2id$v=19$m=...,t=...,p=...$salt$hash), bcrypt keeps
+// its own $2a$cost$... form which the PHC format leaves as is.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(hash, password string) (bool, error)
+	// Owns reports whether hash was made by this algorithm.
+	Owns(hash string) bool
+	// NeedsRehash reports whether hash was made with weaker parameters than
+	// the hasher is configured with.
+	NeedsRehash(hash string) bool
+}
+
+var (
+	current PasswordHasher = &Argon2id{
+		Memory:      config.DefaultArgon2Memory,
+		Iterations:  config.DefaultArgon2Iterations,
+		Parallelism: config.DefaultArgon2Parallelism,
+	}
+	hashers = []PasswordHasher{
+		current,
+		&Bcrypt{Cost: config.DefaultBcryptCost},
+	}
+)
+
+// Configure picks the hasher for new hashes, the others are still used to
+// check existing hashes.
+func Configure(cfg *config.PasswordHashing) {
+	argon := &Argon2id{
+		Memory:      cfg.Argon2Memory,
+		Iterations:  cfg.Argon2Iterations,
+		Parallelism: cfg.Argon2Parallelism,
+	}
+	bcryptHasher := &Bcrypt{Cost: cfg.BcryptCost}
+
+	hashers = []PasswordHasher{argon, bcryptHasher}
+
+	if cfg.Algorithm == config.HashAlgorithmBcrypt {
+		current = bcryptHasher
+	} else {
+		current = argon
+	}
+}
+
+func Hash(password string) (string, error) {
+	return current.Hash(password)
+}
+
+// MaxPasswordBytes is the longest password the configured hasher takes, 0
+// for no limit. bcrypt refuses anything over 72 bytes.
+func MaxPasswordBytes() int {
+	if _, ok := current.(*Bcrypt); ok {
+		return bcryptMaxPasswordBytes
+	}
+
+	return 0
+}
+
+func Verify(hash, password string) (bool, error) {
+	for _, h := range hashers {
+		if h.Owns(hash) {
+			return h.Verify(hash, password)
+		}
+	}
+
+	return false, ErrUnknownHash
+}
+
+// NeedsRehash reports whether hash should be replaced by a hash from the
+// configured hasher, because it uses another algorithm or weaker parameters.
+func NeedsRehash(hash string) bool {
+	if !current.Owns(hash) {
+		return true
+	}
+
+	return current.NeedsRehash(hash)
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+var argon2Encoding = base64.RawStdEncoding
+
+type Argon2id struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+type argon2Hash struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func (a *Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, argon2KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.Memory, a.Iterations, a.Parallelism,
+		argon2Encoding.EncodeToString(salt), argon2Encoding.EncodeToString(key)), nil
+}
+
+func (a *Argon2id) Verify(hash, password string) (bool, error) {
+	parsed, err := parseArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey([]byte(password), parsed.salt, parsed.iterations, parsed.memory, parsed.parallelism, uint32(len(parsed.key)))
+
+	return subtle.ConstantTimeCompare(key, parsed.key) == 1, nil
+}
+
+func (a *Argon2id) Owns(hash string) bool {
+	_, err := parseArgon2id(hash)
+	return err == nil
+}
+
+func (a *Argon2id) NeedsRehash(hash string) bool {
+	parsed, err := parseArgon2id(hash)
+	if err != nil {
+		return true
+	}
+
+	return parsed.memory < a.Memory || parsed.iterations < a.Iterations || parsed.parallelism < a.Parallelism
+}
+
+func parseArgon2id(hash string) (*argon2Hash, error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, ErrUnknownHash
+	}
+
+	// argon2.IDKey panics on zero iterations or parallelism
+	parsed := &argon2Hash{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &parsed.memory, &parsed.iterations, &parsed.parallelism); err != nil ||
+		parsed.iterations == 0 || parsed.parallelism == 0 {
+		return nil, ErrUnknownHash
+	}
+
+	var err error
+	if parsed.salt, err = argon2Encoding.DecodeString(parts[4]); err != nil {
+		return nil, ErrUnknownHash
+	}
+
+	if parsed.key, err = argon2Encoding.DecodeString(parts[5]); err != nil || len(parsed.key) == 0 {
+		return nil, ErrUnknownHash
+	}
+
+	return parsed, nil
+}
+
+const bcryptMaxPasswordBytes = 72
+
+type Bcrypt struct {
+	Cost int
+}
+
+func (b *Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+func (b *Bcrypt) Verify(hash, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (b *Bcrypt) Owns(hash string) bool {
+	_, err := bcrypt.Cost([]byte(hash))
+	return err == nil
+}
+
+func (b *Bcrypt) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < b.Cost
+}
@@ -2,6 +2,7 @@ package validation
 
 import (
 	"errors"
+	"reflect"
 
 	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/validator/v10"
@@ -16,6 +17,8 @@ func RegisterCustomValidation() {
 	}
 }
 
+// UniqueField passes when no user has the value yet, or when the user that
+// has it is the one being validated, identified by the struct's Id field.
 func UniqueField(fl validator.FieldLevel) bool {
 	value := fl.Field().String()
 
@@ -27,18 +30,14 @@ func UniqueField(fl validator.FieldLevel) bool {
 		return true
 	}
 
-	parentIDField := fl.Parent().FieldByName("ID")
-	if !parentIDField.IsValid() {
+	if result.Error != nil {
 		return false
 	}
 
-	parentID := parentIDField.Interface().(uint)
-
-	duplicateField := model.User{}
-	duplicateResult := database.DB.Table("users").Where(field+" = ?", value).First(&duplicateField)
-	if !errors.Is(duplicateResult.Error, gorm.ErrRecordNotFound) && duplicateField.Id != uint(parentID) {
+	parentIDField := fl.Parent().FieldByName("Id")
+	if !parentIDField.IsValid() || parentIDField.Kind() != reflect.Uint {
 		return false
 	}
 
-	return true
+	return user.Id == uint(parentIDField.Uint())
 }
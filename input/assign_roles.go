@@ -0,0 +1,5 @@
+package input
+
+type AssignRolesInput struct {
+	Roles []string `json:"roles" binding:"required,min=1,unique"`
+}
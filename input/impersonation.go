@@ -0,0 +1,6 @@
+package input
+
+type ImpersonateInput struct {
+	// Reason is kept in the audit log, like a ticket number
+	Reason string `json:"reason" binding:"required,max=500"`
+}
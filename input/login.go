@@ -3,4 +3,7 @@ package input
 type LoginInput struct{
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
+	// Scope is optional, a space separated list of scopes to limit the
+	// tokens to
+	Scope string `json:"scope"`
 }
\ No newline at end of file
@@ -0,0 +1,7 @@
+package input
+
+type MagicLinkInput struct {
+	Email string `json:"email" binding:"required,email"`
+	// Scope is optional, the tokens the link logs in with are limited to it
+	Scope string `json:"scope"`
+}
@@ -0,0 +1,10 @@
+package input
+
+type MfaCodeInput struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type LoginMfaInput struct {
+	MfaToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
@@ -0,0 +1,10 @@
+package input
+
+type CreateOAuthClientInput struct {
+	Name         string   `json:"name" binding:"required,max=100"`
+	RedirectURIs []string `json:"redirect_uris" binding:"omitempty,unique"`
+	GrantTypes   []string `json:"grant_types" binding:"required,min=1,unique"`
+	Scopes       []string `json:"scopes" binding:"required,min=1,unique"`
+	// Confidential clients get a secret, public ones (spa, mobile) do not
+	Confidential bool `json:"confidential"`
+}
@@ -0,0 +1,9 @@
+package input
+
+// CreatePersonalAccessTokenInput makes a token that never expires when
+// ExpiresInDays is 0.
+type CreatePersonalAccessTokenInput struct {
+	Name          string   `json:"name" binding:"required,max=100"`
+	Scopes        []string `json:"scopes" binding:"required,min=1,unique"`
+	ExpiresInDays int      `json:"expires_in_days" binding:"omitempty,min=1,max=365"`
+}
@@ -0,0 +1,15 @@
+package input
+
+// UpdateProfileInput leaves a field unchanged when it is empty. Id has to be
+// set to the current user before binding so uniqueField accepts the user's
+// own username and email.
+type UpdateProfileInput struct {
+	Id       uint   `json:"-"`
+	Username string `json:"username" binding:"omitempty,uniqueField=username"`
+	Email    string `json:"email" binding:"omitempty,email,uniqueField=email"`
+}
+
+type ChangePasswordInput struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
@@ -5,7 +5,10 @@ import (
 	"log"
 
 	"github.com/gin-gonic/gin"
+	"github.com/yosikez/crudAuth/auth"
+	"github.com/yosikez/crudAuth/config"
 	"github.com/yosikez/crudAuth/database"
+	"github.com/yosikez/crudAuth/helper/hasher"
 	"github.com/yosikez/crudAuth/helper/validation"
 	"github.com/yosikez/crudAuth/router"
 	"github.com/yosikez/crudAuth/rabbitmq"
@@ -19,6 +22,123 @@ func main() {
 		panic(err)
 	}
 
+	// signing keys
+	jwtCfg, err := config.LoadJWT()
+	if err != nil {
+		log.Fatalf("failed to load jwt config : %v", err)
+	}
+
+	if err := auth.InitKeyRing(jwtCfg); err != nil {
+		log.Fatalf("failed to initialize signing keys : %v", err)
+	}
+
+	go auth.StartKeyRotation(config.SigningKeyCheckInterval)
+
+	// app
+	appCfg, err := config.LoadApp()
+	if err != nil {
+		log.Fatalf("failed to load app config : %v", err)
+	}
+
+	// brute-force protection
+	loginCfg, err := config.LoadLoginProtection()
+	if err != nil {
+		log.Fatalf("failed to load login protection config : %v", err)
+	}
+
+	// oauth
+	oauthCfg, err := config.LoadOAuth()
+	if err != nil {
+		log.Fatalf("failed to load oauth config : %v", err)
+	}
+
+	if oauthCfg.TestClientRedirectURI != "" {
+		if err := auth.EnsureOAuthTestClient(oauthCfg.TestClientRedirectURI); err != nil {
+			log.Fatalf("failed to create oauth test client : %v", err)
+		}
+	}
+
+	// password authenticators
+	authBackends, err := config.LoadAuthBackends()
+	if err != nil {
+		log.Fatalf("failed to load authenticator config : %v", err)
+	}
+
+	var ldapCfg *config.Ldap
+	for _, backend := range authBackends {
+		if backend == config.AuthSourceLdap {
+			if ldapCfg, err = config.LoadLdap(); err != nil {
+				log.Fatalf("failed to load ldap config : %v", err)
+			}
+		}
+	}
+
+	if err := auth.InitAuthenticators(authBackends, ldapCfg); err != nil {
+		log.Fatalf("failed to initialize authenticators : %v", err)
+	}
+
+	// external login providers
+	federationCfg, err := config.LoadFederation()
+	if err != nil {
+		log.Fatalf("failed to load external login config : %v", err)
+	}
+
+	auth.InitFederation(federationCfg)
+
+	// saml single sign-on
+	samlCfg, err := config.LoadSaml()
+	if err != nil {
+		log.Fatalf("failed to load saml config : %v", err)
+	}
+
+	if err := auth.InitSaml(samlCfg, appCfg); err != nil {
+		log.Fatalf("failed to initialize saml : %v", err)
+	}
+
+	// password hashing
+	hashingCfg, err := config.LoadPasswordHashing()
+	if err != nil {
+		log.Fatalf("failed to load password hashing config : %v", err)
+	}
+
+	hasher.Configure(hashingCfg)
+
+	// password policy
+	passwordCfg, err := config.LoadPasswordPolicy()
+	if err != nil {
+		log.Fatalf("failed to load password policy config : %v", err)
+	}
+
+	if err := auth.InitPasswordPolicy(passwordCfg); err != nil {
+		log.Fatalf("failed to initialize password policy : %v", err)
+	}
+
+	// first admin, needs the password policy
+	adminCfg, err := config.LoadBootstrapAdmin()
+	if err != nil {
+		log.Fatalf("failed to load admin config : %v", err)
+	}
+
+	if err := auth.EnsureBootstrapAdmin(adminCfg); err != nil {
+		log.Fatalf("failed to create the first admin : %v", err)
+	}
+
+	// passkeys
+	webAuthnCfg, err := config.LoadWebAuthn()
+	if err != nil {
+		log.Fatalf("failed to load webauthn config : %v", err)
+	}
+
+	if err := auth.InitWebAuthn(webAuthnCfg); err != nil {
+		log.Fatalf("failed to initialize webauthn : %v", err)
+	}
+
+	// purge expired entries from the access token revocation list
+	go auth.StartRevokedTokenPurger(config.RevokedTokenPurgeInterval)
+
+	// purge login failures that no longer count
+	go auth.StartLoginFailurePurger(config.LoginFailurePurgeInterval)
+
 	// rabbitmq
 	rmqCfg, rmq, err := rabbitmq.NewRabbitMQ()
 	if err != nil {
@@ -44,8 +164,13 @@ func main() {
 
 	// declare gin.Engine
 	r := gin.Default()
+	// only the configured proxies may say who the client is, the login
+	// throttling counts per client ip
+	if err := r.SetTrustedProxies(loginCfg.TrustedProxies); err != nil {
+		log.Fatalf("failed to set trusted proxies : %v", err)
+	}
 	// register the route
-	router.RegisterRoute(r, rmq, rmqCfg)
+	router.RegisterRoute(r, rmq, rmqCfg, appCfg, loginCfg)
 	// register the custom validation
 	validation.RegisterCustomValidation()
 	// run the server on port 8000
@@ -7,6 +7,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
+	"github.com/yosikez/crudAuth/auth"
 	"github.com/yosikez/crudAuth/config"
 )
 
@@ -21,6 +22,11 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if tokenString := strings.TrimPrefix(authHeader, "Bearer "); tokenString != authHeader && auth.IsPersonalAccessToken(tokenString) {
+			personalAccessTokenAuth(c, tokenString)
+			return
+		}
+
 		token, err := verifyToken(authHeader)
 
 		if err != nil {
@@ -31,16 +37,67 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		claims, ok :=  token.Claims.(*config.Claims)
-		if !ok || !token.Valid {
+		// refresh, verification and mfa tokens are signed with the same keys,
+		// only tokens meant as access tokens are accepted. Client credentials
+		// tokens have no user and are meant for other apps, not this api.
+		if !ok || !token.Valid || claims.Audience != config.AccessTokenAudience || claims.Id == 0 {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 				"error" : "invalid token",
 			})
 			return
 		}
 
+		revoked, err := auth.IsAccessTokenRevoked(claims.StandardClaims.Id)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"error": "failed to check token revocation",
+			})
+			return
+		}
+
+		if revoked {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "token has been revoked",
+			})
+			return
+		}
+
+		if claims.SessionId != "" {
+			active, err := auth.IsSessionActive(claims.SessionId)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+					"error": "failed to check session",
+				})
+				return
+			}
+
+			if !active {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+					"error": "session has been revoked",
+				})
+				return
+			}
+		}
+
 		c.Set("username", claims.Username)
 		c.Set("userId", claims.Id)
 		c.Set("userEmail", claims.Email)
+		c.Set("tokenId", claims.StandardClaims.Id)
+		c.Set("tokenExpiresAt", claims.ExpiresAt)
+		c.Set("sessionId", claims.SessionId)
+		c.Set("clientId", claims.ClientId)
+		c.Set("emailVerified", claims.EmailVerified)
+		c.Set("roles", claims.Roles)
+		c.Set("permissions", claims.Permissions)
+		c.Set("scopes", strings.Fields(claims.Scope))
+
+		// impersonation is flagged on every response, so whatever support
+		// sees or changes is never mistaken for the user's own doing
+		if claims.Act != nil {
+			c.Set("actor", claims.Act)
+			c.Header(config.ImpersonatedByHeader, claims.Act.Username)
+		}
+
 		c.Next()
 	}
 }
@@ -52,9 +109,7 @@ func verifyToken(authHeader string) (*jwt.Token, error) {
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 	
-	token, err := jwt.ParseWithClaims(tokenString, &config.Claims{}, func(t *jwt.Token) (interface{}, error) {
-		return []byte(config.SecretKey), nil
-	})
+	token, err := auth.ParseToken(tokenString, &config.Claims{})
 
 	if err != nil {
 		return nil, err
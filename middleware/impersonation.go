@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DenyImpersonation must run after AuthMiddleware, it keeps impersonation
+// tokens away from routes that manage credentials and sessions, acting as a
+// user is for looking, not for taking over the account.
+func DenyImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, impersonated := c.Get("actor"); impersonated {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "not allowed while impersonating a user",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DenyOAuthClients must run after AuthMiddleware, it keeps tokens issued to
+// oauth clients away from routes that manage credentials and sessions.
+func DenyOAuthClients() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("clientId") != "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "not allowed with a token issued to an oauth client",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
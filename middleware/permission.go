@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequirePermission must run after AuthMiddleware, it rejects requests whose
+// access token does not carry the given permission.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, p := range c.GetStringSlice("permissions") {
+			if p == permission {
+				c.Next()
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "missing permission " + permission,
+		})
+	}
+}
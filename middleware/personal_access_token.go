@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yosikez/crudAuth/auth"
+)
+
+// personalAccessTokenAuth sets the same context keys as a jwt access token,
+// a personal access token has no session and no jti, it is identified by
+// personalAccessTokenId instead.
+func personalAccessTokenAuth(c *gin.Context, token string) {
+	pat, user, err := auth.AuthenticatePersonalAccessToken(token)
+
+	if errors.Is(err, auth.ErrInvalidPersonalAccessToken) || errors.Is(err, auth.ErrUserDisabled) || errors.Is(err, auth.ErrPasswordResetRequired) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "invalid token",
+		})
+		return
+	}
+
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to check personal access token",
+		})
+		return
+	}
+
+	var expiresAt int64
+	if pat.ExpiresAt != nil {
+		expiresAt = pat.ExpiresAt.Unix()
+	}
+
+	c.Set("username", user.Username)
+	c.Set("userId", user.Id)
+	c.Set("userEmail", user.Email)
+	c.Set("tokenExpiresAt", expiresAt)
+	c.Set("personalAccessTokenId", pat.Id)
+	c.Set("emailVerified", user.EmailVerified)
+	c.Set("roles", user.RoleNames())
+	c.Set("permissions", auth.PersonalAccessTokenPermissions(pat, user))
+	c.Set("scopes", pat.ScopeNames())
+	c.Next()
+}
+
+// DenyPersonalAccessTokens must run after AuthMiddleware, it keeps personal
+// access tokens away from routes that manage credentials and sessions, so a
+// leaked token can not be turned into a full login.
+func DenyPersonalAccessTokens() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetUint("personalAccessTokenId") != 0 {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "not allowed with a personal access token",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireScope must run after AuthMiddleware, it rejects requests whose token
+// was not granted the given scope, whatever the user's permissions are.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, s := range c.GetStringSlice("scopes") {
+			if s == scope {
+				c.Next()
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "missing scope " + scope,
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yosikez/crudAuth/config"
+)
+
+// RequireVerifiedEmail must run after AuthMiddleware and applies the
+// configured policy to users who have not verified their email yet.
+func RequireVerifiedEmail(policy string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetBool("emailVerified") || policy == config.UnverifiedUserAllow {
+			c.Next()
+			return
+		}
+
+		if policy == config.UnverifiedUserReadOnly && (c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead) {
+			c.Next()
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "email address is not verified",
+		})
+	}
+}
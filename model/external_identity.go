@@ -0,0 +1,46 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ExternalIdentity links an account of an external openid connect provider,
+// identified by its issuer's subject, to a user.
+type ExternalIdentity struct {
+	Id       uint      `gorm:"column:id" json:"id"`
+	UserId   uint      `gorm:"column:user_id;index" json:"user_id"`
+	Provider string    `gorm:"column:provider;uniqueIndex:idx_external_identity_subject" json:"provider"`
+	Subject  string    `gorm:"column:subject;uniqueIndex:idx_external_identity_subject" json:"subject"`
+	Email    string    `gorm:"column:email" json:"email"`
+	CreateAt time.Time `gorm:"column:created_at" json:"created_at"`
+	// CreatedUser is set when the account was made for this identity rather
+	// than linked by email, only then may the provider manage its roles
+	CreatedUser bool `gorm:"column:created_user" json:"created_user"`
+}
+
+// ExternalLoginState keeps what is needed to finish a login at an external
+// provider, it is looked up by the hash of the state parameter.
+type ExternalLoginState struct {
+	StateHash    string    `gorm:"column:state_hash;primaryKey" json:"-"`
+	Provider     string    `gorm:"column:provider" json:"provider"`
+	Nonce        string    `gorm:"column:nonce" json:"-"`
+	CodeVerifier string    `gorm:"column:code_verifier" json:"-"`
+	Scope        string    `gorm:"column:scope" json:"scope"`
+	ExpiresAt    time.Time `gorm:"column:expires_at;index" json:"expires_at"`
+}
+
+func (e *ExternalIdentity) BeforeCreate(tx *gorm.DB) error {
+	e.CreateAt = time.Now()
+	return nil
+}
+
+// SamlRequest is an AuthnRequest waiting for its response, it is looked up
+// by the hash of the RelayState.
+type SamlRequest struct {
+	RelayStateHash string    `gorm:"column:relay_state_hash;primaryKey" json:"-"`
+	RequestId      string    `gorm:"column:request_id" json:"request_id"`
+	Scope          string    `gorm:"column:scope" json:"scope"`
+	ExpiresAt      time.Time `gorm:"column:expires_at;index" json:"expires_at"`
+}
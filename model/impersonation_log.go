@@ -0,0 +1,32 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ImpersonationLog is the audit trail of impersonation tokens. Usernames are
+// copied in, so the entry still says who was involved after an account is
+// deleted.
+type ImpersonationLog struct {
+	Id            uint      `gorm:"column:id" json:"id"`
+	ActorId       uint      `gorm:"column:actor_id;index" json:"actor_id"`
+	ActorUsername string    `gorm:"column:actor_username" json:"actor_username"`
+	UserId        uint      `gorm:"column:user_id;index" json:"user_id"`
+	Username      string    `gorm:"column:username" json:"username"`
+	Reason        string    `gorm:"column:reason" json:"reason"`
+	TokenId       string    `gorm:"column:token_id" json:"-"`
+	IpAddress     string    `gorm:"column:ip_address" json:"ip_address"`
+	UserAgent     string    `gorm:"column:user_agent" json:"user_agent"`
+	ExpiresAt     time.Time `gorm:"column:expires_at" json:"expires_at"`
+	CreateAt      time.Time `gorm:"column:created_at;index" json:"created_at"`
+	// RevokedAt is set when an admin ended the impersonation before its
+	// token expired
+	RevokedAt *time.Time `gorm:"column:revoked_at" json:"revoked_at"`
+}
+
+func (i *ImpersonationLog) BeforeCreate(tx *gorm.DB) error {
+	i.CreateAt = time.Now()
+	return nil
+}
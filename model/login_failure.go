@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+// LoginFailure counts recent failed logins for one key, either a username
+// ("user:<name>") or a client ip ("ip:<addr>").
+type LoginFailure struct {
+	Key          string     `gorm:"column:key;primaryKey" json:"key"`
+	Count        int        `gorm:"column:count" json:"count"`
+	LastFailedAt time.Time  `gorm:"column:last_failed_at" json:"last_failed_at"`
+	LockedUntil  *time.Time `gorm:"column:locked_until" json:"locked_until"`
+}
@@ -0,0 +1,88 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OauthClient is an app that may get tokens from the oauth server. Public
+// clients, like single page and mobile apps, have no secret. RedirectURIs,
+// GrantTypes and Scopes are space separated.
+type OauthClient struct {
+	Id           string    `gorm:"column:id;primaryKey" json:"client_id"`
+	Name         string    `gorm:"column:name" json:"name"`
+	SecretHash   string    `gorm:"column:secret_hash" json:"-"`
+	RedirectURIs string    `gorm:"column:redirect_uris" json:"-"`
+	GrantTypes   string    `gorm:"column:grant_types" json:"-"`
+	Scopes       string    `gorm:"column:scopes" json:"-"`
+	CreateAt     time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+func (c *OauthClient) BeforeCreate(tx *gorm.DB) error {
+	c.CreateAt = time.Now()
+	return nil
+}
+
+func (c *OauthClient) IsConfidential() bool {
+	return c.SecretHash != ""
+}
+
+func (c *OauthClient) RedirectURIList() []string {
+	return strings.Fields(c.RedirectURIs)
+}
+
+func (c *OauthClient) GrantTypeList() []string {
+	return strings.Fields(c.GrantTypes)
+}
+
+func (c *OauthClient) ScopeList() []string {
+	return strings.Fields(c.Scopes)
+}
+
+func (c *OauthClient) AllowsGrant(grantType string) bool {
+	for _, g := range c.GrantTypeList() {
+		if g == grantType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// OauthAuthorizationCode is handed to the client's redirect uri after the
+// user consented, it can be exchanged for tokens once.
+type OauthAuthorizationCode struct {
+	CodeHash      string    `gorm:"column:code_hash;primaryKey" json:"-"`
+	ClientId      string    `gorm:"column:client_id;index" json:"client_id"`
+	UserId        uint      `gorm:"column:user_id;index" json:"user_id"`
+	RedirectURI   string    `gorm:"column:redirect_uri" json:"redirect_uri"`
+	Scope         string    `gorm:"column:scope" json:"scope"`
+	CodeChallenge string    `gorm:"column:code_challenge" json:"-"`
+	Nonce         string    `gorm:"column:nonce" json:"-"`
+	AuthTime      time.Time `gorm:"column:auth_time" json:"auth_time"`
+	ExpiresAt     time.Time `gorm:"column:expires_at" json:"expires_at"`
+}
+
+const (
+	DeviceCodePending  = "pending"
+	DeviceCodeApproved = "approved"
+	DeviceCodeDenied   = "denied"
+)
+
+// OauthDeviceCode is a device authorization, rfc 8628. The device polls with
+// the device code while the user enters the user code on another device.
+type OauthDeviceCode struct {
+	DeviceCodeHash string     `gorm:"column:device_code_hash;primaryKey" json:"-"`
+	UserCode       string     `gorm:"column:user_code;uniqueIndex" json:"-"`
+	ClientId       string     `gorm:"column:client_id;index" json:"client_id"`
+	Scope          string     `gorm:"column:scope" json:"scope"`
+	Status         string     `gorm:"column:status" json:"status"`
+	UserId         *uint      `gorm:"column:user_id;index" json:"user_id"`
+	AuthTime       *time.Time `gorm:"column:auth_time" json:"auth_time"`
+	PollInterval   int        `gorm:"column:poll_interval" json:"poll_interval"`
+	LastPolledAt   *time.Time `gorm:"column:last_polled_at" json:"last_polled_at"`
+	ExpiresAt      time.Time  `gorm:"column:expires_at;index" json:"expires_at"`
+	FailedLookups  int        `gorm:"column:failed_lookups" json:"-"`
+}
@@ -0,0 +1,21 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PasswordHistory keeps the hashes of passwords a user has had, so the
+// password policy can refuse reusing them.
+type PasswordHistory struct {
+	Id           uint      `gorm:"column:id" json:"id"`
+	UserId       uint      `gorm:"column:user_id;index" json:"user_id"`
+	PasswordHash string    `gorm:"column:password_hash" json:"-"`
+	CreateAt     time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+func (p *PasswordHistory) BeforeCreate(tx *gorm.DB) error {
+	p.CreateAt = time.Now()
+	return nil
+}
@@ -0,0 +1,21 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type PasswordResetToken struct {
+	Id        uint       `gorm:"column:id" json:"id"`
+	UserId    uint       `gorm:"column:user_id;index" json:"user_id"`
+	TokenHash string     `gorm:"column:token_hash;uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `gorm:"column:expires_at" json:"expires_at"`
+	UsedAt    *time.Time `gorm:"column:used_at" json:"used_at"`
+	CreateAt  time.Time  `gorm:"column:created_at" json:"created_at"`
+}
+
+func (t *PasswordResetToken) BeforeCreate(tx *gorm.DB) error {
+	t.CreateAt = time.Now()
+	return nil
+}
@@ -0,0 +1,38 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PersonalAccessToken lets scripts call the api as the user without a
+// password. Scopes is a comma separated list of the scopes the token is
+// limited to, it still needs the user's permissions as well.
+type PersonalAccessToken struct {
+	Id         uint       `gorm:"column:id" json:"id"`
+	UserId     uint       `gorm:"column:user_id;index" json:"user_id"`
+	Name       string     `gorm:"column:name" json:"name"`
+	TokenHash  string     `gorm:"column:token_hash;uniqueIndex" json:"-"`
+	Scopes     string     `gorm:"column:scopes" json:"-"`
+	ExpiresAt  *time.Time `gorm:"column:expires_at" json:"expires_at"`
+	LastUsedAt *time.Time `gorm:"column:last_used_at" json:"last_used_at"`
+	CreateAt   time.Time  `gorm:"column:created_at" json:"created_at"`
+}
+
+func (t *PersonalAccessToken) BeforeCreate(tx *gorm.DB) error {
+	t.CreateAt = time.Now()
+	return nil
+}
+
+func (t *PersonalAccessToken) ScopeNames() []string {
+	names := []string{}
+	for _, scope := range strings.Split(t.Scopes, ",") {
+		if scope != "" {
+			names = append(names, scope)
+		}
+	}
+
+	return names
+}
@@ -1,40 +1,31 @@
 package model
 
 import (
-	"os"
+	"time"
 
-	"github.com/golang-jwt/jwt"
 	"gorm.io/gorm"
 )
 
+// RefreshToken is a login session on one device. The session id is also the
+// refresh token family: every token minted by rotating it carries the same
+// sid, and only the hash of the latest one is kept in Token. Sessions
+// started through the oauth server belong to ClientId.
 type RefreshToken struct {
-	gorm.Model
-	Token    string `gorm:"refresh_token" json:"refresh_token"`
-	UserId   uint   `gorm:"user_id"`
-	Username string `gorm:"username"`
+	Id         uint      `gorm:"column:id" json:"-"`
+	Token      string    `gorm:"column:token" json:"-"`
+	UserId     uint      `gorm:"column:user_id;index"`
+	Username   string    `gorm:"column:username"`
+	SessionId  string    `gorm:"column:session_id;uniqueIndex"`
+	ClientId   string    `gorm:"column:client_id;index"`
+	UserAgent  string    `gorm:"column:user_agent"`
+	IpAddress  string    `gorm:"column:ip_address"`
+	CreateAt   time.Time `gorm:"column:created_at"`
+	LastUsedAt time.Time `gorm:"column:last_used_at"`
 }
 
-type TokenClaims struct {
-	Id       uint   `json:"id"`
-	Username string `json:"username"`
-	jwt.StandardClaims
-}
-
-var secretKey = os.Getenv("SECRET_KEY")
-
-func (t *RefreshToken) IsValid(refreshToken string) bool {
-	token, err := jwt.ParseWithClaims(refreshToken, &TokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secretKey), nil
-	})
-
-	if err != nil || !token.Valid {
-		return false
-	}
-
-	claims, ok := token.Claims.(*TokenClaims)
-	if !ok {
-		return false
-	}
-
-	return t.UserId == claims.Id
+func (t *RefreshToken) BeforeCreate(tx *gorm.DB) error {
+	now := time.Now()
+	t.CreateAt = now
+	t.LastUsedAt = now
+	return nil
 }
@@ -0,0 +1,19 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type RevokedToken struct {
+	Jti       string    `gorm:"column:jti;primaryKey" json:"jti"`
+	UserId    uint      `gorm:"column:user_id;index" json:"user_id"`
+	ExpiresAt time.Time `gorm:"column:expires_at;index" json:"expires_at"`
+	CreateAt  time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+func (r *RevokedToken) BeforeCreate(tx *gorm.DB) error {
+	r.CreateAt = time.Now()
+	return nil
+}
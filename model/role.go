@@ -0,0 +1,32 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type Role struct {
+	Id          uint         `gorm:"column:id" json:"id"`
+	Name        string       `gorm:"column:name;unique" json:"name"`
+	Permissions []Permission `gorm:"many2many:role_permissions;constraint:OnDelete:CASCADE" json:"permissions"`
+	CreateAt    time.Time    `gorm:"column:created_at" json:"created_at"`
+	UpdateAt    time.Time    `gorm:"column:updated_at" json:"updated_at"`
+}
+
+type Permission struct {
+	Id   uint   `gorm:"column:id" json:"id"`
+	Name string `gorm:"column:name;unique" json:"name"`
+}
+
+func (r *Role) BeforeCreate(tx *gorm.DB) error {
+	now := time.Now()
+	r.CreateAt = now
+	r.UpdateAt = now
+	return nil
+}
+
+func (r *Role) BeforeUpdate(tx *gorm.DB) error {
+	r.UpdateAt = time.Now()
+	return nil
+}
@@ -0,0 +1,24 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SigningKey is a private key used to sign tokens. A key signs from
+// ActivatesAt until a newer key activates, and is still published for
+// verification until ExpiresAt, which is only set once it is superseded.
+type SigningKey struct {
+	Kid         string     `gorm:"column:kid;primaryKey" json:"kid"`
+	Algorithm   string     `gorm:"column:algorithm" json:"algorithm"`
+	PrivateKey  string     `gorm:"column:private_key;type:text" json:"-"`
+	ActivatesAt time.Time  `gorm:"column:activates_at;index" json:"activates_at"`
+	ExpiresAt   *time.Time `gorm:"column:expires_at" json:"expires_at"`
+	CreateAt    time.Time  `gorm:"column:created_at" json:"created_at"`
+}
+
+func (k *SigningKey) BeforeCreate(tx *gorm.DB) error {
+	k.CreateAt = time.Now()
+	return nil
+}
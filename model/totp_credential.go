@@ -0,0 +1,34 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type TotpCredential struct {
+	Id           uint       `gorm:"column:id" json:"id"`
+	UserId       uint       `gorm:"column:user_id;uniqueIndex" json:"user_id"`
+	Secret       string     `gorm:"column:secret" json:"-"`
+	ConfirmedAt  *time.Time `gorm:"column:confirmed_at" json:"confirmed_at"`
+	LastUsedStep int64      `gorm:"column:last_used_step" json:"-"`
+	CreateAt     time.Time  `gorm:"column:created_at" json:"created_at"`
+}
+
+type RecoveryCode struct {
+	Id       uint       `gorm:"column:id" json:"id"`
+	UserId   uint       `gorm:"column:user_id;index" json:"user_id"`
+	CodeHash string     `gorm:"column:code_hash" json:"-"`
+	UsedAt   *time.Time `gorm:"column:used_at" json:"used_at"`
+	CreateAt time.Time  `gorm:"column:created_at" json:"created_at"`
+}
+
+func (t *TotpCredential) BeforeCreate(tx *gorm.DB) error {
+	t.CreateAt = time.Now()
+	return nil
+}
+
+func (r *RecoveryCode) BeforeCreate(tx *gorm.DB) error {
+	r.CreateAt = time.Now()
+	return nil
+}
@@ -3,27 +3,42 @@ package model
 import (
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/yosikez/crudAuth/helper/hasher"
 	"gorm.io/gorm"
 )
 
 type User struct {
-	Id       uint      `gorm:"column:id" json:"id"`
-	Username string    `gorm:"column:username;unique" binding:"required,uniqueField=username" json:"username"`
-	Email    string    `gorm:"column:email;unique" binding:"required,uniqueField=email" json:"email"`
-	Password string    `gorm:"column:password" json:"password"`
-	CreateAt time.Time `gorm:"column:created_at" json:"created_at"`
-	UpdateAt time.Time `gorm:"column:updated_at" json:"updated_at"`
+	Id                    uint       `gorm:"column:id" json:"id"`
+	Username              string     `gorm:"column:username;unique" binding:"required,uniqueField=username" json:"username"`
+	Email                 string     `gorm:"column:email;unique" binding:"required,email,uniqueField=email" json:"email"`
+	Password              string     `gorm:"column:password" binding:"required" json:"password"`
+	Roles                 []Role     `gorm:"many2many:user_roles;constraint:OnDelete:CASCADE" json:"roles,omitempty" binding:"-"`
+	IsDisabled            bool       `gorm:"column:is_disabled;default:false" json:"is_disabled"`
+	PasswordResetRequired bool       `gorm:"column:password_reset_required;default:false" json:"password_reset_required"`
+	EmailVerified         bool       `gorm:"column:email_verified;default:false" json:"email_verified"`
+	AuthSource            string     `gorm:"column:auth_source;default:local" json:"auth_source" binding:"-"`
+	VerificationSentAt    *time.Time `gorm:"column:verification_sent_at" json:"-"`
+	PasswordResetSentAt   *time.Time `gorm:"column:password_reset_sent_at" json:"-"`
+	MagicLinkSentAt       *time.Time `gorm:"column:magic_link_sent_at" json:"-"`
+	CreateAt              time.Time  `gorm:"column:created_at" json:"created_at"`
+	UpdateAt              time.Time  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// HashPassword hashes with the configured hasher, see hasher.Configure.
+// BeforeCreate hashes the password of a new account, later changes go
+// through auth.setPassword.
+func HashPassword(password string) (string, error) {
+	return hasher.Hash(password)
 }
 
 func (u *User) BeforeCreate(tx *gorm.DB) error {
 	now := time.Now()
-	hash, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+	hash, err := HashPassword(u.Password)
 	if err != nil {
 		return err
 	}
 
-	u.Password = string(hash)
+	u.Password = hash
 	u.CreateAt = now
 	u.UpdateAt = now
 
@@ -34,3 +49,30 @@ func (u *User) BeforeUpdate(tx *gorm.DB) error {
 	u.UpdateAt = time.Now()
 	return nil
 }
+
+func (u *User) RoleNames() []string {
+	names := make([]string, 0, len(u.Roles))
+	for _, role := range u.Roles {
+		names = append(names, role.Name)
+	}
+
+	return names
+}
+
+// PermissionNames returns the union of the permissions of all the user's
+// roles, Roles.Permissions has to be preloaded.
+func (u *User) PermissionNames() []string {
+	seen := map[string]bool{}
+	names := []string{}
+
+	for _, role := range u.Roles {
+		for _, permission := range role.Permissions {
+			if !seen[permission.Name] {
+				seen[permission.Name] = true
+				names = append(names, permission.Name)
+			}
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,38 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type WebauthnCredential struct {
+	Id              uint       `gorm:"column:id" json:"id"`
+	UserId          uint       `gorm:"column:user_id;index" json:"user_id"`
+	Name            string     `gorm:"column:name" json:"name"`
+	CredentialId    []byte     `gorm:"column:credential_id;uniqueIndex" json:"-"`
+	PublicKey       []byte     `gorm:"column:public_key" json:"-"`
+	AttestationType string     `gorm:"column:attestation_type" json:"-"`
+	Transports      string     `gorm:"column:transports" json:"-"`
+	AAGUID          []byte     `gorm:"column:aaguid" json:"-"`
+	SignCount       uint32     `gorm:"column:sign_count" json:"-"`
+	BackupEligible  bool       `gorm:"column:backup_eligible" json:"backup_eligible"`
+	BackupState     bool       `gorm:"column:backup_state" json:"backup_state"`
+	LastUsedAt      *time.Time `gorm:"column:last_used_at" json:"last_used_at"`
+	CreateAt        time.Time  `gorm:"column:created_at" json:"created_at"`
+}
+
+// WebauthnSession holds the challenge of a registration or login ceremony
+// between its begin and finish requests.
+type WebauthnSession struct {
+	Id        string    `gorm:"column:id;primaryKey" json:"id"`
+	UserId    uint      `gorm:"column:user_id" json:"user_id"`
+	Kind      string    `gorm:"column:kind" json:"kind"`
+	Data      string    `gorm:"column:data;type:text" json:"-"`
+	ExpiresAt time.Time `gorm:"column:expires_at;index" json:"expires_at"`
+}
+
+func (w *WebauthnCredential) BeforeCreate(tx *gorm.DB) error {
+	w.CreateAt = time.Now()
+	return nil
+}
@@ -0,0 +1,40 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/yosikez/crudAuth/config"
+)
+
+// Publish sends payload as json to the configured exchange, using the routing
+// key as the queue name so consumers can bind to it the same way the todo
+// queues are bound. It is a variable so tests can catch the messages without
+// a broker.
+var Publish = publish
+
+func publish(conn *config.RabbitMQConnection, cfg *config.RabbitMQ, routingKey string, payload interface{}) error {
+	q, err := conn.Channel.QueueDeclare(routingKey, false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := conn.Channel.QueueBind(q.Name, routingKey, cfg.ExchangeName, false, nil); err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return conn.Channel.PublishWithContext(ctx, cfg.ExchangeName, routingKey, false, false, amqp.Publishing{
+		ContentType: "application/json",
+		Body:        body,
+	})
+}
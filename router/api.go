@@ -7,21 +7,133 @@ import (
 	"github.com/yosikez/crudAuth/middleware"
 )
 
-func RegisterRoute(router *gin.Engine, conn *config.RabbitMQConnection, rmqCfg *config.RabbitMQ) {
+func RegisterRoute(router *gin.Engine, conn *config.RabbitMQConnection, rmqCfg *config.RabbitMQ, appCfg *config.App, loginCfg *config.LoginProtection) {
 	
-	authController := controller.NewAuthController()
+	authController := controller.NewAuthController(conn, rmqCfg, appCfg, loginCfg)
 	todoController := controller.NewTodoController(conn, rmqCfg)
+	sessionController := controller.NewSessionController()
+	keyController := controller.NewKeyController()
+	roleController := controller.NewRoleController()
+	userController := controller.NewUserController()
+	profileController := controller.NewProfileController(conn, rmqCfg, appCfg)
+	mfaController := controller.NewMfaController()
+	passkeyController := controller.NewPasskeyController()
+	personalAccessTokenController := controller.NewPersonalAccessTokenController()
+	oauthController := controller.NewOAuthController(authController)
+	oauthClientController := controller.NewOAuthClientController()
+	oidcController := controller.NewOidcController(appCfg)
+	externalLoginController := controller.NewExternalLoginController(authController)
+	samlController := controller.NewSamlController(authController)
+
+	// credentials and sessions can only be managed after a real login, not
+	// with a personal access token, a token of an oauth client or while
+	// impersonating
+	noPersonalAccessToken := middleware.DenyPersonalAccessTokens()
+	noOAuthClient := middleware.DenyOAuthClients()
+	noImpersonation := middleware.DenyImpersonation()
+
+	router.GET("/.well-known/jwks.json", keyController.JWKS)
+	router.GET("/.well-known/openid-configuration", oidcController.Discovery)
 
 	router.POST("/register", authController.Register)
 	router.POST("/login", authController.Login)
+	router.POST("/login/mfa", authController.LoginMfa)
+	router.POST("/login/magic", authController.MagicLogin)
+	router.GET("/login/magic/callback", authController.MagicLoginCallback)
+	router.POST("/login/passkey/begin", authController.BeginPasskeyLogin)
+	router.POST("/login/passkey/finish", authController.FinishPasskeyLogin)
+	router.GET("/login/external", externalLoginController.Providers)
+	router.GET("/login/external/:provider", externalLoginController.Begin)
+	router.GET("/login/external/:provider/callback", externalLoginController.Callback)
+	router.GET("/saml/metadata", samlController.Metadata)
+	router.GET("/saml/login", samlController.Login)
+	router.POST("/saml/acs", samlController.Acs)
 	router.POST("/refresh-token", authController.RefreshToken)
+	router.POST("/logout", middleware.AuthMiddleware(), noPersonalAccessToken, noImpersonation, authController.Logout)
+	router.POST("/logout-all", middleware.AuthMiddleware(), noPersonalAccessToken, noImpersonation, authController.LogoutAll)
+	router.GET("/verify-email", authController.VerifyEmail)
+	router.POST("/verify-email/resend", authController.ResendVerification)
+	router.POST("/password/forgot", authController.ForgotPassword)
+	router.GET("/password/reset", authController.PasswordResetPage)
+	router.POST("/password/reset", authController.ResetPassword)
+
+	router.GET("/oauth/authorize", oauthController.Authorize)
+	router.POST("/oauth/authorize", oauthController.Approve)
+	router.POST("/oauth/token", oauthController.Token)
+	router.POST("/oauth/revoke", oauthController.Revoke)
+	router.POST("/introspect", oauthController.Introspect)
+	router.POST("/device/code", oauthController.DeviceAuthorization)
+	router.GET("/device", oauthController.Device)
+	router.POST("/device", oauthController.ApproveDevice)
+
+	userInfo := router.Group("/userinfo", middleware.AuthMiddleware(), middleware.RequireScope(config.ScopeOpenId))
+	userInfo.GET("", oidcController.UserInfo)
+	userInfo.POST("", oidcController.UserInfo)
+
+	protected := router.Group("/api", middleware.AuthMiddleware(), middleware.RequireVerifiedEmail(appCfg.UnverifiedUserPolicy))
+
+	canReadTodos := middleware.RequirePermission(config.PermissionTodosRead)
+	canWriteTodos := middleware.RequirePermission(config.PermissionTodosWrite)
+
+	// every route needs a scope on top of the permission, so a token limited
+	// to todos:read can not write todos even when its user could
+	todosRead := protected.Group("", middleware.RequireScope(config.ScopeTodosRead))
+	todosWrite := protected.Group("", middleware.RequireScope(config.ScopeTodosWrite))
+	profile := protected.Group("", middleware.RequireScope(config.ScopeProfile))
+	account := protected.Group("", middleware.RequireScope(config.ScopeAccount), noPersonalAccessToken, noOAuthClient, noImpersonation)
+
+	todosRead.GET("/todos", canReadTodos, todoController.FindAll)
+	todosRead.GET("/todos/:id", canReadTodos, todoController.FindById)
+	todosWrite.POST("/todos", canWriteTodos, todoController.Create)
+	todosWrite.POST("/todos/:id/done", canWriteTodos, todoController.DoneTodo)
+	todosWrite.PUT("/todos/:id", canWriteTodos, todoController.Update)
+	todosWrite.DELETE("/todos/:id", canWriteTodos, todoController.Delete)
+
+	profile.GET("/me", profileController.Show)
+	profile.PATCH("/me", noPersonalAccessToken, noOAuthClient, noImpersonation, profileController.Update)
+
+	account.POST("/me/password", profileController.ChangePassword)
+
+	account.POST("/mfa/totp/enroll", mfaController.EnrollTotp)
+	account.POST("/mfa/totp/confirm", mfaController.ConfirmTotp)
+	account.DELETE("/mfa/totp", mfaController.DisableTotp)
+	account.POST("/mfa/recovery-codes", mfaController.RegenerateRecoveryCodes)
+
+	account.GET("/passkeys", passkeyController.FindAll)
+	account.POST("/passkeys/register/begin", passkeyController.BeginRegistration)
+	account.POST("/passkeys/register/finish", passkeyController.FinishRegistration)
+	account.DELETE("/passkeys/:id", passkeyController.Delete)
+
+	account.GET("/tokens", personalAccessTokenController.FindAll)
+	account.POST("/tokens", personalAccessTokenController.Create)
+	account.DELETE("/tokens/:id", personalAccessTokenController.Delete)
+
+	account.GET("/sessions", sessionController.FindAll)
+	account.DELETE("/sessions/:id", sessionController.Delete)
+
+	// a leaked personal access token or client token must not reach the admin
+	// routes, an admin has to log in for them
+	admin := router.Group("/admin", middleware.AuthMiddleware(), middleware.RequireScope(config.ScopeAdmin), noPersonalAccessToken, noOAuthClient)
+
+	admin.GET("/roles", middleware.RequirePermission(config.PermissionRolesRead), roleController.FindAll)
+	admin.PUT("/users/:id/roles", middleware.RequirePermission(config.PermissionRolesWrite), roleController.AssignToUser)
+
+	canReadUsers := middleware.RequirePermission(config.PermissionUsersRead)
+	canWriteUsers := middleware.RequirePermission(config.PermissionUsersWrite)
 
-	protected := router.Group("/api", middleware.AuthMiddleware())
+	admin.GET("/users", canReadUsers, userController.FindAll)
+	admin.GET("/users/:id", canReadUsers, userController.FindById)
+	admin.POST("/users/:id/disable", canWriteUsers, userController.Disable)
+	admin.POST("/users/:id/enable", canWriteUsers, userController.Enable)
+	admin.POST("/users/:id/force-password-reset", canWriteUsers, userController.ForcePasswordReset)
+	admin.POST("/users/:id/unlock", canWriteUsers, userController.Unlock)
+	admin.POST("/users/:id/logout", canWriteUsers, userController.Logout)
+	admin.DELETE("/users/:id", canWriteUsers, userController.Delete)
+	admin.POST("/users/:id/impersonate", middleware.RequirePermission(config.PermissionUsersImpersonate), userController.Impersonate)
+	admin.GET("/impersonations", canReadUsers, userController.Impersonations)
+	admin.DELETE("/impersonations/:id", canWriteUsers, userController.RevokeImpersonation)
 
-	protected.GET("/todos", todoController.FindAll)
-	protected.GET("/todos/:id", todoController.FindById)
-	protected.POST("/todos", todoController.Create)
-	protected.POST("/todos/:id/done", todoController.DoneTodo)
-	protected.PUT("/todos/:id", todoController.Update)
-	protected.DELETE("/todos/:id", todoController.Delete)
+	admin.GET("/oauth/clients", middleware.RequirePermission(config.PermissionClientsRead), oauthClientController.FindAll)
+	admin.POST("/oauth/clients", middleware.RequirePermission(config.PermissionClientsWrite), oauthClientController.Create)
+	admin.DELETE("/oauth/clients/:id", middleware.RequirePermission(config.PermissionClientsWrite), oauthClientController.Delete)
 }
\ No newline at end of file